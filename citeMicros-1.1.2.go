@@ -3,16 +3,21 @@ package main
 //***Import Block: imports necessary libraries***
 
 import (
-	"encoding/csv"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"regexp"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
@@ -23,19 +28,42 @@ var clog = lumber.NewConsoleLogger(lumber.INFO)
 
 //***Type Defenition Block: defines necessary data structures***
 
-//Stores CTSURN information. Used in splitCTS, isCTSURN and functions in the Endpoint Handling Block.
+//Stores a CTS URN split into its components. Filled by ParseCTSURN and used throughout the Library and Endpoint Handling Blocks.
 type CTSURN struct {
-	Stem      string
-	Reference string
+	Namespace string
+	TextGroup string
+	Work      string
+	Version   string //empty for notional works
+	Exemplar  string
+	Begin     CTSPassage //the passage reference, or the first end of a range
+	End       CTSPassage //the last end of a range
+	Range     bool
+}
+
+//Stores a passage reference without range: its citation components and an optional subreference like @μῆνις[1]. Used in CTSURN.
+type CTSPassage struct {
+	Components   []string
+	Subreference string //subreference token without @ and index
+	Index        int    //occurrence of the subreference token given in brackets; 0 if none is given
 }
 
 //Stores Node information. Used in NodeResponse.
 type Node struct {
-	URN      []string `json:"urn"`
-	Text     []string `json:"text,omitempty"`
-	Previous []string `json:"previous"`
-	Next     []string `json:"next"`
-	Index    int      `json:"sequence"`
+	URN       []string   `json:"urn"`
+	Text      []string   `json:"text,omitempty"`
+	Previous  []string   `json:"previous"`
+	Next      []string   `json:"next"`
+	Index     int        `json:"sequence"`
+	Subtext   *Substring `json:"substring,omitempty"` //part of Text a subreference of the request points to
+	Requested bool       `json:"requested,omitempty"` //set by /texts/context for the nodes of the requested passage
+}
+
+//Stores the part of a node text identified by a subreference. Offsets count characters of the node text. Used in Node and URNResponse.
+type Substring struct {
+	URN   string `json:"urn"`
+	Text  string `json:"text"`
+	Start int    `json:"start"` //offset of the first character
+	End   int    `json:"end"`   //offset after the last character
 }
 
 //Stores version information which are added to CITEResponse for further processing. Used in ReturnCiteVersion
@@ -71,32 +99,110 @@ type NodeResponse struct {
 	Service    string   `json:"service"`
 	Message    string   `json:"message,omitempty"`
 	URN        []string `json:"urns,omitempty"`
+	Tier       string   `json:"tier,omitempty"` //citation tier of the containers in URN, e.g. book
 	Nodes      []Node   `json:""`
+	LibraryInfo
 }
 
 //Stores URN response results, which are passed to ReturnWorkURNS for further processing, parsing to JSON format and displaying. Used in ParseURNS.
 type URNResponse struct {
-	requestURN []string `json:"requestURN"`
-	Status     string   `json:"status"`
-	Service    string   `json:"service"`
-	Message    string   `json:"message,omitempty"`
-	URN        []string `json:"urns"`
+	requestURN []string    `json:"requestURN"`
+	Status     string      `json:"status"`
+	Service    string      `json:"service"`
+	Message    string      `json:"message,omitempty"`
+	URN        []string    `json:"urns"`
+	Substrings []Substring `json:"substrings,omitempty"`
+	LibraryInfo
+}
+
+//Stores who published a library and under which license. Embedded in the responses of every endpoint serving a library; set by Library.Info.
+type LibraryInfo struct {
+	LibraryURN string `json:"libraryUrn,omitempty"`
+	License    string `json:"license,omitempty"`
+}
+
+//Stores library response results, which are parsed to JSON format and displayed. Used in ReturnLibrary.
+type LibraryResponse struct {
+	Status      string      `json:"status"`
+	Service     string      `json:"service"`
+	Message     string      `json:"message,omitempty"`
+	CiteLibrary CiteLibrary `json:"citelibrary"`
+	CEXVersion  string      `json:"cexVersion"` //version declared by #!cexversion, or undeclared
+	LibraryInfo
 }
 
 //Stores catalog response results, which are parsed to JSON format and displayed. Used in ReturnCatalog.
 type CatalogResponse struct {
-	Status  string   `json:"status"`
-	Service string   `json:"service"`
-	Message string   `json:"message,omitempty"`
-	URN     []string `json:"urns"`
+	Status  string         `json:"status"`
+	Service string         `json:"service"`
+	Message string         `json:"message,omitempty"`
+	URN     []string       `json:"urns"`
+	Entries []CatalogEntry `json:"entries,omitempty"`
+	LibraryInfo
 }
 
-//Stores work information for transfer to other functions. Used in ParseWork and the Endpoint Handling Block.
+//Stores work information for transfer to other functions. Used in ParseWork, the Library Block and the Endpoint Handling Block.
 type Work struct {
-	WorkURN string
-	URN     []string
-	Text    []string
-	Index   []int
+	WorkURN   string
+	URN       []string
+	Text      []string
+	Index     []int
+	NodeIndex map[string]int    //position of every node URN in URN; filled by LoadLibrary
+	Citation  *CitationNode     //root of the citation hierarchy; filled by LoadLibrary
+	lines     []int             //line number of every node in the CEX source; 0 for derived nodes
+	sources   []string          //CEX source of every node of a merged library; filled by MergeCEX and addNode
+	levels    [][]*CitationNode //nodes of the citation index by depth in document order; filled by addCitation
+	Tiers     []string          //citation tiers from the citationScheme of the catalog entry, nil if not catalogued; filled by buildLibrary
+}
+
+//Stores a node of the citation hierarchy of a Work: a container like a book or chapter, or a citable node. Used in the Citation Index Block.
+type CitationNode struct {
+	Reference string          //dotted passage reference; empty for the root
+	Depth     int             //number of passage components; 0 for the root
+	Parent    *CitationNode   //nil for the root
+	Children  []*CitationNode //in document order
+	Position  int             //position in Work.URN if this is a citable node, otherwise -1
+	First     int             //position in Work.URN of the first citable node at or below this node
+	Last      int             //position in Work.URN of the last citable node at or below this node
+	Index     int             //position among the nodes at the same depth in document order
+	children  map[string]*CitationNode
+}
+
+//Stores a container or citable node of the citation hierarchy of a Work, labelled with its citation tier. Used in CitationResponse.
+type Citation struct {
+	URN   string `json:"urn"`
+	Tier  string `json:"tier"` //tier from the citationScheme, e.g. book; version or exemplar for the root
+	Depth int    `json:"depth"`
+	Leaf  bool   `json:"leaf"`  //whether this is a citable node with text
+	First string `json:"first"` //first citable node at or below this one
+	Last  string `json:"last"`  //last citable node at or below this one
+}
+
+//Stores citation response results, which are parsed to JSON format and displayed. Used in ReturnChildren, ReturnParent and ReturnAncestors.
+type CitationResponse struct {
+	Status    string     `json:"status"`
+	Service   string     `json:"service"`
+	Message   string     `json:"message,omitempty"`
+	Citations []Citation `json:"citations"`
+	LibraryInfo
+}
+
+//Stores a container of the table of contents of a Work with the size of its text and the containers below it. Used in TOCResponse.
+type TOCEntry struct {
+	Citation
+	Leaves     int        `json:"leaves"`     //number of citable nodes at or below the container
+	Characters int        `json:"characters"` //number of characters of their text
+	Words      int        `json:"words"`      //number of whitespace separated words of their text
+	Children   []TOCEntry `json:"children,omitempty"`
+}
+
+//Stores table of contents response results, which are parsed to JSON format and displayed. Used in ReturnTOC.
+type TOCResponse struct {
+	Status  string    `json:"status"`
+	Service string    `json:"service"`
+	Message string    `json:"message,omitempty"`
+	TOC     *TOCEntry `json:"toc,omitempty"`
+	LibraryInfo
 }
 
 //Holds multiple Works. Not in use yet.
@@ -106,14 +212,26 @@ type Collection struct {
 
 //Stores CEX catalog entry information. Format of CEX catalog seems not to be fixed yet...
 type CatalogEntry struct {
-	URN            string
-	CitationScheme string
-	GroupName      string
-	WorkTitle      string
-	VersionLabel   string
-	ExemplarLabel  string
-	Online         string
-	Lang           string
+	URN            string   `json:"urn"`
+	CitationScheme string   `json:"citationScheme"`
+	GroupName      string   `json:"groupName"`
+	WorkTitle      string   `json:"workTitle"`
+	VersionLabel   string   `json:"versionLabel"`
+	ExemplarLabel  string   `json:"exemplarLabel,omitempty"`
+	Online         string   `json:"online"`
+	Lang           string   `json:"lang"`
+	CitationTiers  []string `json:"citationTiers"` //CitationScheme split at the secondary delimiter
+	line           int      //line number in the CEX source
+	source         string   //CEX source of the line in merged libraries
+}
+
+//Stores the filters of a /catalog request, given as query parameters. Empty filters match every entry. Used in ReturnCatalog.
+type CatalogFilter struct {
+	Group  string //text group identifier or group name
+	Work   string //work identifier or work title
+	Lang   string
+	Online string //true or false
+	Type   string //version or exemplar
 }
 
 //Stores catalog entries. Used in ParseCatalog to transfer results to ReturnCatalog.
@@ -121,9 +239,21 @@ type Catalog struct {
 	CatalogEntries []CatalogEntry
 }
 
-//Stores a sourcetext. Used by parsing functions and in Endpoint Handling Block.
+//Stores the text of a CEX source and the delimiters used in it. Used by parsing functions in the Library Block.
 type CTSParams struct {
-	Sourcetext string
+	Sourcetext         string
+	Delimiter          string   //column delimiter; detected from the source if empty
+	SecondaryDelimiter string   //delimiter of citation tiers and vocabulary lists; detected from the source if empty
+	Rules              CEXRules //rules of the CEX version declared by the source; set by ParseCEX
+}
+
+//Stores the parsing rules that differ between versions of the CEX specification. Selected by RulesFor.
+type CEXRules struct {
+	Version         string              //versions the rules apply to, e.g. 3.x; empty for sources without #!cexversion
+	CatalogColumns  [2]int              //minimum and maximum number of columns of #!ctscatalog lines
+	CatalogHeader   bool                //whether the first line of #!ctscatalog has to be a header line
+	RequiredBlocks  []string            //blocks every source of the version has to contain
+	DependentBlocks map[string][]string //blocks a source has to contain if it contains the block of the key
 }
 
 //Stores server configuration. Used in all functions that need access to server parameters and the source.
@@ -132,77 +262,338 @@ type ServerConfig struct {
 	Port       string `json:"port"`
 	Source     string `json:"cex_source"`
 	TestSource string `json:"test_cex_source"`
+	LibraryConfig
+	Libraries map[string]LibraryConfig `json:"libraries,omitempty"` //settings for single libraries, keyed by library name
 }
 
-//***Helpfunction Block: These functions perform tasks that are necessary in multiple functions in the Endpoint Handling Block***
+//Stores the parsing settings of a library. The settings at the top level of the configuration apply to all libraries unless overridden in ServerConfig.Libraries.
+type LibraryConfig struct {
+	Delimiter          string   `json:"delimiter,omitempty"`
+	SecondaryDelimiter string   `json:"secondary_delimiter,omitempty"`
+	Strictness         string   `json:"strictness,omitempty"` //"lenient" (default) skips bad lines, "strict" rejects a source with any bad line
+	Sources            []string `json:"sources,omitempty"`    //names of libraries or locations of CEX files composed into this library
+}
+
+//Stores a content line of a CEX block together with its line number in the CEX source. Used in CEXBlock.
+type CEXLine struct {
+	Number int
+	Text   string
+}
+
+//Stores a labelled block of a CEX source without empty lines and // comments. Filled by SplitCEXBlocks.
+type CEXBlock struct {
+	Label string //block label without the leading #!, e.g. ctsdata
+	Line  int    //line number of the block label
+	Lines []CEXLine
+}
+
+//Stores the contents of a #!citelibrary block.
+type CiteLibrary struct {
+	Name       string      `json:"name"`
+	URN        string      `json:"urn"`
+	License    string      `json:"license"`
+	Namespaces []Namespace `json:"namespaces,omitempty"`
+}
+
+//Stores a namespace abbreviation used in the URNs of a library and the URI it stands for. Used in CiteLibrary.
+type Namespace struct {
+	Abbreviation string `json:"abbreviation"`
+	URI          string `json:"uri"`
+}
+
+//Stores a line of a #!citecollections block.
+type CiteCollection struct {
+	URN               string `json:"urn"`
+	Description       string `json:"description"`
+	LabellingProperty string `json:"labellingProperty,omitempty"`
+	OrderingProperty  string `json:"orderingProperty,omitempty"`
+	License           string `json:"license"`
+	line              int    //line number in the CEX source
+	source            string //CEX source of the line in merged libraries
+}
+
+//Stores a line of a #!citeproperties block.
+type CiteProperty struct {
+	URN        string   `json:"urn"`
+	Label      string   `json:"label"`
+	Type       string   `json:"type"`
+	Vocabulary []string `json:"vocabulary,omitempty"`
+	line       int      //line number in the CEX source
+	source     string   //CEX source of the line in merged libraries
+}
+
+//Stores a #!citedata block: the header naming the property of each column and one record per object.
+type CiteDataBlock struct {
+	Header  []string
+	Records [][]string
+	Lines   []int  //line number of each record
+	source  string //CEX source of the block in merged libraries
+}
+
+//Stores a CITE2 URN, split into its components. Used in the CITE Collection Block.
+type Cite2URN struct {
+	Namespace  string
+	Collection string
+	Version    string //empty for a notional collection
+	Property   string //property identifier of property URNs like urn:cite2:hmt:msA.v1.label:
+	Begin      string //object identifier, or the first object of a range
+	End        string //last object of a range
+	Range      bool
+	Extension  string //extended reference after @, like the region of interest of an image
+}
+
+//Stores a CITE collection with its properties and objects. Filled by BuildCollections.
+type ObjectCollection struct {
+	Definition  CiteCollection
+	Properties  []CiteProperty
+	Objects     []CiteObject   //sorted by the ordering property in ordered collections
+	ObjectIndex map[string]int //position of every object URN in Objects
+}
+
+//Stores an object of a CITE collection with its typed property values. Used in ObjectResponse.
+type CiteObject struct {
+	URN        string          `json:"urn"`
+	Label      string          `json:"label"`
+	Properties []PropertyValue `json:"properties"`
+}
+
+//Stores the value of a property of one object, e.g. urn:cite2:hmt:msA.v1.label:1r. Value is a string, float64, bool or nil.
+type PropertyValue struct {
+	URN      string      `json:"urn"`
+	Label    string      `json:"label"`
+	Type     string      `json:"type"`
+	Value    interface{} `json:"value"`
+	property string      //URN of the property in #!citeproperties
+}
+
+//Stores the description of a collection returned by /collections.
+type CollectionDescription struct {
+	CiteCollection
+	Ordered    bool           `json:"ordered"`
+	Properties []CiteProperty `json:"properties"`
+	Size       int            `json:"size"`
+}
+
+//Stores collection response results, which are parsed to JSON format and displayed. Used in ReturnCollections.
+type CollectionResponse struct {
+	Status      string                  `json:"status"`
+	Service     string                  `json:"service"`
+	Message     string                  `json:"message,omitempty"`
+	Collections []CollectionDescription `json:"collections,omitempty"`
+	LibraryInfo
+}
+
+//Stores object response results, which are parsed to JSON format and displayed. Used in ReturnObjects.
+type ObjectResponse struct {
+	Status  string       `json:"status"`
+	Service string       `json:"service"`
+	Message string       `json:"message,omitempty"`
+	Objects []CiteObject `json:"objects,omitempty"`
+	LibraryInfo
+}
+
+//Stores a line of an #!imagedata block.
+type ImageExtension struct {
+	Collection string `json:"collection"`
+	Protocol   string `json:"protocol"`
+	BaseURL    string `json:"baseUrl,omitempty"`
+	License    string `json:"license,omitempty"`
+}
+
+//Stores a subject-verb-object triple of a #!relations block.
+type Relation struct {
+	Subject string `json:"subject"`
+	Verb    string `json:"verb"`
+	Object  string `json:"object"`
+}
+
+//Stores relation response results, which are parsed to JSON format and displayed. Used in ReturnRelations.
+type RelationResponse struct {
+	Status    string     `json:"status"`
+	Service   string     `json:"service"`
+	Message   string     `json:"message,omitempty"`
+	Relations []Relation `json:"relations,omitempty"`
+	LibraryInfo
+}
+
+//Stores the consistency problems of a library, which are parsed to JSON format and displayed. Used in ReturnConsistency.
+type ConsistencyResponse struct {
+	Status   string       `json:"status"`
+	Service  string       `json:"service"`
+	Message  string       `json:"message,omitempty"`
+	Problems []ParseError `json:"problems,omitempty"`
+	LibraryInfo
+}
 
-//Splits CTS string s into its Stem and Reference. Returns CTSURN.
-func splitCTS(s string) CTSURN {
-	var result CTSURN                                                                                         //initialize result as CTSURN
-	result = CTSURN{Stem: strings.Join(strings.Split(s, ":")[0:4], ":"), Reference: strings.Split(s, ":")[4]} //first four parts go into the stem, last (fourth) part goes into reference
-	return result                                                                                             //returns as CTSURN
+//Stores the problems found in one CEX file by the validate subcommand. Used in Validate.
+type ValidationReport struct {
+	File            string       `json:"file"`
+	Version         string       `json:"cexVersion,omitempty"` //version declared by #!cexversion
+	Message         string       `json:"message,omitempty"`    //why the file could not be read
+	Diagnostics     []ParseError `json:"diagnostics"`          //lines the block parsers could not parse
+	Inconsistencies []ParseError `json:"inconsistencies"`      //disagreements between #!ctscatalog and #!ctsdata
 }
 
+//Stores a line of a #!datamodels block.
+type DataModel struct {
+	Collection  string `json:"collection"`
+	Model       string `json:"model"`
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	line        int    //line number in the CEX source
+	source      string //CEX source of the line in merged libraries
+}
+
+//Stores an object of a DSE collection: a text passage, the region of an image showing it and the surface it is written on. Filled by BuildDSE.
+type DSERecord struct {
+	URN      string `json:"urn"`
+	Label    string `json:"label"`
+	Passage  string `json:"passage"`
+	ImageROI string `json:"imageroi"`
+	Surface  string `json:"surface"`
+}
+
+//Stores an object of an ORCA collection: an analysis of a passage, the text deformation it results in and the node of the analytical exemplar
+//holding the deformation. Filled by BuildORCA.
+type ORCARecord struct {
+	URN         string `json:"urn"`
+	Label       string `json:"label"`
+	Passage     string `json:"passage"`
+	Analysis    string `json:"analysis"`
+	Deformation string `json:"deformation"`
+	Exemplar    string `json:"exemplar,omitempty"`
+}
+
+//Stores ORCA response results, which are parsed to JSON format and displayed. Used in ReturnORCA.
+type ORCAResponse struct {
+	Status   string       `json:"status"`
+	Service  string       `json:"service"`
+	Message  string       `json:"message,omitempty"`
+	Analyses []ORCARecord `json:"analyses,omitempty"`
+	LibraryInfo
+}
+
+//Stores DSE response results, which are parsed to JSON format and displayed. Used in ReturnDSE.
+type DSEResponse struct {
+	Status  string      `json:"status"`
+	Service string      `json:"service"`
+	Message string      `json:"message,omitempty"`
+	Records []DSERecord `json:"dse,omitempty"`
+	LibraryInfo
+}
+
+//Stores a problem found while parsing a CEX source: the block, the line number in the source, the offending text and the reason.
+type ParseError struct {
+	Source string `json:"source,omitempty"` //set in merged libraries
+	Block  string `json:"block"`
+	Line   int    `json:"line"`
+	Text   string `json:"text"`
+	Reason string `json:"reason"`
+}
+
+//Reports why a CEX source could not be loaded as a library. Diagnostics is empty if the source could not be fetched at all.
+type LibraryError struct {
+	Source      string       `json:"source"`
+	Message     string       `json:"message"`
+	Diagnostics []ParseError `json:"diagnostics,omitempty"`
+}
+
+//Stores exception response results for requests that failed because their library could not be loaded. Used in writeLibraryException.
+type LibraryExceptionResponse struct {
+	Status      string       `json:"status"`
+	Service     string       `json:"service"`
+	Message     string       `json:"message"`
+	Diagnostics []ParseError `json:"diagnostics,omitempty"`
+	LibraryInfo
+}
+
+//Stores the typed contents of all blocks of a CEX source. Filled by ParseCEX.
+type CEXData struct {
+	Blocks             []CEXBlock
+	Delimiter          string
+	SecondaryDelimiter string
+	Version            string
+	CiteLibrary        CiteLibrary
+	Catalog            Catalog
+	CTSData            Work
+	Collections        []CiteCollection
+	Properties         []CiteProperty
+	CiteData           []CiteDataBlock
+	ImageData          []ImageExtension
+	Relations          []Relation
+	DataModels         []DataModel
+	Diagnostics        []ParseError //problems found while parsing; the offending lines are skipped
+}
+
+//Stores a CEX source that has been parsed once and indexed by work URN. Used in the Library Block and the Endpoint Handling Block.
+type Library struct {
+	Name            string           //name used in the {CEX} route segment; empty for the test source
+	Source          string           //location the CEX data was loaded from
+	WorkURNs        []string         //work URNs in the order of their first node in #!ctsdata
+	Works           map[string]*Work //works keyed by work URN
+	Catalog         Catalog
+	CEX             CEXData                      //all blocks of the source, for the services that are not about texts
+	CollectionURNs  []string                     //collection URNs in the order of #!citecollections
+	Collections     map[string]*ObjectCollection //collections keyed by collection URN
+	DSE             []DSERecord                  //records of all DSE collections
+	ORCA            []ORCARecord                 //records of all ORCA collections
+	Inconsistencies []ParseError                 //disagreements between #!ctscatalog and #!ctsdata found by CheckCatalog
+	Loaded          time.Time
+}
+
+//Stores all libraries loaded so far, keyed by library name. Used through registry in the Endpoint Handling Block.
+type LibraryRegistry struct {
+	mutex      sync.RWMutex           //guards Config, Libraries, Rejected and loading
+	loading    map[string]*sync.Mutex //one lock per library name, so a source is only fetched and parsed once at a time without blocking the others
+	ConfigFile string
+	Config     ServerConfig
+	Libraries  map[string]*Library
+	Rejected   map[string]*LibraryError //sources that failed to parse; kept until the next reload so they are not parsed again for every request
+}
+
+//Stores reload response results, which are parsed to JSON format and displayed. Used in ReturnReload.
+type ReloadResponse struct {
+	Status    string   `json:"status"`
+	Service   string   `json:"service"`
+	Message   string   `json:"message,omitempty"`
+	Libraries []string `json:"libraries"`
+}
+
+var registry = NewLibraryRegistry("./config.json")
+
+//***Helpfunction Block: These functions perform tasks that are necessary in multiple functions in the Endpoint Handling Block***
+
 //Loads and parses JSON file defined by string s. Returns ServerConfig.
 func LoadConfiguration(file string) ServerConfig {
 	var config ServerConfig          //initialize config as ServerConfig
 	configFile, err := os.Open(file) //attempt to open file
 	defer configFile.Close()         //push closing on call list
 	if err != nil {                  //error handling
-		fmt.Println(err.Error())
+		fmt.Fprintln(os.Stderr, err.Error())
 	}
 	jsonParser := json.NewDecoder(configFile) //initialize jsonParser with configFile
 	jsonParser.Decode(&config)                //parse configFile to config
 	return config                             //return ServerConfig config
 }
 
-//Returns boolf for wether string slice s contains string e.
-func contains(s []string, e string) bool {
-	for _, a := range s {
-		if a == e {
-			return true
-		}
-	}
-	return false
-}
-
-//Returns bool for wether string s resembles a URN-range. Called in ReturnReff and ReturnPassage.
-func isRange(s string) bool {
-	switch {
-	case len(strings.Split(s, ":")) < 5: //Test wether URN has reference..
-		return false //..else its not a range.
-	case strings.Contains(strings.Split(s, ":")[4], "-"): //The Reference must contain a hyphen ('-') indicating a range of URNs.
-		return true //Than it should be a range.
-	default: //In any other case..
-		return false //...its not a CTS URN range.
+//Returns the smaller of a and b.
+func minInt(a int, b int) int {
+	if a < b {
+		return a
 	}
+	return b
 }
 
-//Returns bool for whether length and structure of string s indicate it is a valid CTS URN. Called in Endpoint Handling Block.
-func isCTSURN(s string) bool {
-	clog.Info("Testing wether \"" + s + "\" is a valid CTS URN")
-	test := strings.Split(s, ":") //initializes string array by splitting string into functional parts.
-	switch {
-	case len(test) < 4: //URN has to have at least 4 parts
-		clog.Warn("Not a valid CTS URN: not enough fields. (Should be 4 or 5)")
-		return false
-	case len(test) > 5: //URN may not have more thatn 5 parts.
-		clog.Warn("Not a valid CTS URN: too many fields. (Should be 4 or 5)")
-		return false
-	case test[0] != "urn": //First field of URN must be "urn"
-		clog.Warn("Not a valid CTS URN: first field must be urn")
-		return false
-	case test[1] != "cts": //Second field of URN must be "cts"
-		clog.Warn("Not a valid CTS URN: second field must be cts")
-		return false
-	default:
-		clog.Info("CTS URN is valid")
-		return true
+//Returns the larger of a and b.
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
 	}
+	return b
 }
 
-//Returns bool for wether bool e is contained in bool slice s.
-func boolcontains(s []bool, e bool) bool {
+//Returns boolf for wether string slice s contains string e.
+func contains(s []string, e string) bool {
 	for _, a := range s {
 		if a == e {
 			return true
@@ -211,46 +602,6 @@ func boolcontains(s []bool, e bool) bool {
 	return false
 }
 
-//Returns bool for wether string e is contained in string slice s on level 1 of the URN.
-func level1contains(s []string, e string) bool {
-	var match []bool   //initialize bool array match
-	for i := range s { //go through string array. if regex matches string plus one level
-		match2, _ := regexp.MatchString((e + "([:|.]*[0-9|a-z]+)$"), s[i])
-		match = append(match, match2)
-	}
-	return boolcontains(match, true)
-}
-
-//Returns bool for wether string e is contained in string slice s on level 2 of the URN.
-func level2contains(s []string, e string) bool {
-	var match []bool
-	for i := range s {
-		match2, _ := regexp.MatchString((e + "([:|.]*[0-9|a-z]+).([0-9|a-z]+)$"), s[i])
-		match = append(match, match2)
-	}
-	return boolcontains(match, true)
-}
-
-//Returns bool for wether string e is contained in string slice s on level 3 of the URN.
-func level3contains(s []string, e string) bool {
-	var match []bool
-	for i := range s {
-		match2, _ := regexp.MatchString((e + "([:|.]*[0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+)$"), s[i])
-		match = append(match, match2)
-	}
-	return boolcontains(match, true)
-}
-
-//Returns bool for wether string e is contained in string slice s on level 4 of the URN.
-func level4contains(s []string, e string) bool {
-	var match []bool
-	for i := range s {
-		match2, _ := regexp.MatchString((e + "([:|.]*[0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+)$"), s[i])
-		match = append(match, match2)
-	}
-	return boolcontains(match, true)
-}
-
 //Removes dublicate URNs from elements. Returns a slice of all unique elements.
 func removeDuplicatesUnordered(elements []string) []string {
 	encountered := map[string]bool{} //initalize bool map with string keys
@@ -266,228 +617,2825 @@ func removeDuplicatesUnordered(elements []string) []string {
 	return result
 }
 
-//***Main Block***
-
-//Initializes mux server, loads configuration from config file, sets the serverIP, maps endpoints to respective funtions. Initialises the headers.
-func main() {
-	clog.Info("Starting up local server.")
-	confvar := LoadConfiguration("./config.json")
-	serverIP := confvar.Port
-	router := mux.NewRouter().StrictSlash(true)
-	router.HandleFunc("/cite", ReturnCiteVersion)
-	router.HandleFunc("/texts", ReturnWorkURNS)
-	router.HandleFunc("/texts/version", ReturnTextsVersion)
-	router.HandleFunc("/catalog", ReturnCatalog)
-	router.HandleFunc("/texts/first/{URN}", ReturnFirst)
-	router.HandleFunc("/texts/last/{URN}", ReturnLast)
-	router.HandleFunc("/texts/previous/{URN}", ReturnPrev)
-	router.HandleFunc("/texts/next/{URN}", ReturnNext)
-	router.HandleFunc("/texts/urns/{URN}", ReturnReff)
-	router.HandleFunc("/catalog/{URN}", ReturnCatalog)
-	router.HandleFunc("/texts/{URN}", ReturnPassage)
-	router.HandleFunc("/{CEX}/texts/", ReturnWorkURNS)
-	router.HandleFunc("/{CEX}/catalog/", ReturnCatalog)
-	router.HandleFunc("/{CEX}/texts/first/{URN}", ReturnFirst)
-	router.HandleFunc("/{CEX}/texts/last/{URN}", ReturnLast)
-	router.HandleFunc("/{CEX}/texts/previous/{URN}", ReturnPrev)
-	router.HandleFunc("/{CEX}/texts/next/{URN}", ReturnNext)
-	router.HandleFunc("/{CEX}/texts/urns/{URN}", ReturnReff)
-	router.HandleFunc("/{CEX}/catalog/{URN}", ReturnCatalog)
-	router.HandleFunc("/{CEX}/texts/{URN}", ReturnPassage)
-	router.HandleFunc("/", ReturnCiteVersion)
-	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type"})
-	originsOk := handlers.AllowedOrigins([]string{os.Getenv("ORIGIN_ALLOWED")})
-	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS"})
-
-	clog.Info("Server is running")
-	clog.Info("Listening at" + serverIP + "...")
-	log.Fatal(http.ListenAndServe(serverIP, handlers.CORS(originsOk, headersOk, methodsOk)(router)))
+//Returns the entries of the catalog that match the filter, in catalog order.
+func (catalog Catalog) Filter(filter CatalogFilter) []CatalogEntry {
+	var entries []CatalogEntry
+	for _, entry := range catalog.CatalogEntries {
+		if filter.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
 }
 
-//Fetches data from the url. Returns byte slice. Error handling implemented.
-func getContent(url string) ([]byte, error) {
-	resp, err := http.Get(url) //get response from server
+//Returns bool for whether the catalog entry matches every filter that is given. Comparisons ignore case.
+func (filter CatalogFilter) Matches(entry CatalogEntry) bool {
+	urn, err := ParseCTSURN(entry.URN)
 	if err != nil {
-		return nil, fmt.Errorf("GET error: %v", err) //return in case of GET error
+		return false
 	}
-	defer resp.Body.Close() //return in case of http status error
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Status error: %v", resp.StatusCode)
+	switch {
+	case filter.Group != "" && !strings.EqualFold(filter.Group, urn.TextGroup) && !strings.EqualFold(filter.Group, entry.GroupName):
+		return false
+	case filter.Work != "" && !strings.EqualFold(filter.Work, urn.Work) && !strings.EqualFold(filter.Work, urn.TextGroup+"."+urn.Work) && !strings.EqualFold(filter.Work, entry.WorkTitle):
+		return false
+	case filter.Lang != "" && !strings.EqualFold(filter.Lang, entry.Lang):
+		return false
+	case filter.Online != "" && !strings.EqualFold(filter.Online, entry.Online):
+		return false
+	case filter.Type == "version" && urn.Exemplar != "":
+		return false
+	case filter.Type == "exemplar" && urn.Exemplar == "":
+		return false
 	}
-	data, err := ioutil.ReadAll(resp.Body) //read response into byte slice
-	if err != nil {                        //return in case of read error
-		return nil, fmt.Errorf("Read body: %v", err)
+	return true
+}
+
+//Parses result to JSON format and writes it to w.
+func writeJSON(w http.ResponseWriter, result interface{}) {
+	resultJSON, err := json.Marshal(result) //parsing result to JSON format
+	if err != nil {                         //e.g. a value JSON cannot represent
+		clog.Error("Could not encode response: " + err.Error())
+		resultJSON, _ = json.Marshal(LibraryExceptionResponse{Status: "Exception", Service: serviceOf(result), Message: "Could not encode the response: " + err.Error()})
 	}
-	return data, nil
+	w.Header().Set("Content-Type", "application/json; charset=utf-8") //set output format
+	fmt.Fprintln(w, string(resultJSON))                               //output
 }
 
-//ReturnWorkURNS returns the URNs as found in the #!ctsdata block of the CEX file
-func ReturnWorkURNS(w http.ResponseWriter, r *http.Request) {
-	clog.Info("Called function: ReturWorkURNS")
-	confvar := LoadConfiguration("config.json")
-	vars := mux.Vars(r)
-	requestCEX := ""
-	requestCEX = vars["CEX"]
-	var sourcetext string
-	switch {
-	case requestCEX != "":
-		sourcetext = confvar.Source + requestCEX + ".cex"
-		clog.Info("CEX-file provided in URL: " + requestCEX + ". Using " + sourcetext + ".")
-	default:
-		sourcetext = confvar.TestSource
-		clog.Info("No CEX-file provided in URL. Using " + confvar.TestSource + " from congfig instead.")
+//Returns the Service field of a response struct, or an empty string if it has none.
+func serviceOf(result interface{}) string {
+	value := reflect.Indirect(reflect.ValueOf(result))
+	if value.Kind() != reflect.Struct {
+		return ""
 	}
-	result := ParseURNS(CTSParams{Sourcetext: sourcetext})
-	for i := range result.URN {
-		result.URN[i] = strings.Join(strings.Split(result.URN[i], ":")[0:4], ":")
-		result.URN[i] = result.URN[i] + ":"
+	if service := value.FieldByName("Service"); service.IsValid() && service.Kind() == reflect.String {
+		return service.String()
 	}
-	result.URN = removeDuplicatesUnordered(result.URN)
-	result.Service = "/texts"
-	result.requestURN = []string{}
-	resultJSON, _ := json.Marshal(result)
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	fmt.Fprintln(w, string(resultJSON))
-	clog.Info("ReturWorkURNS executed succesfully")
+	return ""
 }
 
+//***Citation Index Block: finds citable nodes and containers in the citation hierarchy of a Work***
 
-func ParseURNS(p CTSParams) URNResponse { //suggestion: change name to ParseURNSFromCTSdata
-	clog.Info("Parsing URNS from #!ctsdata")
-	input_file := p.Sourcetext
-	data, err := getContent(input_file)
-	if err != nil {
-		return URNResponse{Status: "Exception", Message: "Couldn't open connection."}
+//Adds the citable node at position in URN with the passage components to the citation index of the work. Nodes have to be added in document order.
+func (work *Work) addCitation(components []string, position int) {
+	if work.Citation == nil {
+		work.Citation = &CitationNode{Position: -1, First: position, children: map[string]*CitationNode{}}
+		work.levels = [][]*CitationNode{{work.Citation}}
 	}
+	node := work.Citation
+	node.Last = position
+	for depth, component := range components {
+		child, found := node.children[component]
+		if !found {
+			child = &CitationNode{Reference: strings.Join(components[:depth+1], "."), Depth: depth + 1, Parent: node, Position: -1, First: position, children: map[string]*CitationNode{}}
+			node.children[component] = child
+			node.Children = append(node.Children, child)
+			if len(work.levels) <= depth+1 {
+				work.levels = append(work.levels, nil)
+			}
+			child.Index = len(work.levels[depth+1]) //nodes are added in document order, so a new node follows every node at its depth
+			work.levels[depth+1] = append(work.levels[depth+1], child)
+		}
+		child.Last = position
+		node = child
+	}
+	node.Position = position
+}
 
-	str := string(data)
-	// Remove comments
-	str = strings.Split(str, "#!ctsdata")[1]
-	str = strings.Split(str, "#!")[0]
-	re := regexp.MustCompile("(?m)[\r\n]*^//.*$")
-	str = re.ReplaceAllString(str, "")
+//Returns the node of the citation index with the reference of passage, which may be a citable node or a container at any depth. Subreferences are ignored.
+func (work *Work) FindCitation(passage CTSPassage) (*CitationNode, bool) {
+	node := work.Citation
+	if node == nil {
+		return nil, false
+	}
+	for _, component := range passage.Components {
+		child, found := node.children[component]
+		if !found {
+			return nil, false
+		}
+		node = child
+	}
+	return node, true
+}
 
-	reader := csv.NewReader(strings.NewReader(str))
-	reader.Comma = '#'
-	reader.LazyQuotes = true
-	reader.FieldsPerRecord = 2
+//Returns the positions in URN of the first and last citable node identified by urn: the whole work if urn has no passage,
+//a citable node or container with everything below it, or everything from the first end of a range to the last.
+//Returns false if a passage is not in the citation index or a range ends before it begins.
+func (work *Work) Span(urn CTSURN) (int, int, bool) {
+	if work.Citation == nil {
+		return 0, 0, false
+	}
+	if !urn.HasPassage() {
+		return work.Citation.First, work.Citation.Last, true
+	}
+	begin, found := work.FindCitation(urn.Begin)
+	if !found {
+		return 0, 0, false
+	}
+	if !urn.Range {
+		return begin.First, begin.Last, true
+	}
+	end, found := work.FindCitation(urn.End)
+	if !found || end.Last < begin.First {
+		return 0, 0, false
+	}
+	return begin.First, end.Last, true
+}
 
-	var response URNResponse
+//Returns the CTS URN of a container or citable node of the citation index.
+func (work *Work) CitationURN(node *CitationNode) string {
+	return work.WorkURN + ":" + node.Reference
+}
 
-	for {
-		line, error := reader.Read()
-		if error == io.EOF {
-			break
-		} else if error != nil {
-			log.Fatal(error)
-		}
-		response.URN = append(response.URN, line[0])
+//Returns the enclosing containers of node from the root of the citation index down to its parent.
+func (node *CitationNode) Ancestors() []*CitationNode {
+	var ancestors []*CitationNode
+	for parent := node.Parent; parent != nil; parent = parent.Parent {
+		ancestors = append([]*CitationNode{parent}, ancestors...)
 	}
-	response.Status = "Success"
-	clog.Info("URNS parsed succesfully")
-	return response
+	return ancestors
 }
 
-//ParseWork extracts the relevant data out of the Sourcetext.
-func ParseWork(p CTSParams) Work {
-	clog.Info("Parsing work")
-	input_file := p.Sourcetext          //get information out of Sourcetext  (string?)
-	data, err := getContent(input_file) //get data out of input_file
-	if err != nil {
-		log.Fatal(err) //log error
-		return Work{}  //return empty work if saving in data failed
+//Returns the containers and citable nodes of the citation index at depth in document order.
+func (work *Work) CitationsAt(depth int) []*CitationNode {
+	if depth < 0 || depth >= len(work.levels) {
+		return nil
 	}
+	return work.levels[depth]
+}
 
-	str := string(data)                           //save data in str
-	str = strings.Split(str, "#!ctsdata")[1]      //split data at #!ctsdata and take the second part
-	str = strings.Split(str, "#!")[0]             // split at #! and take the first part in case there is any other funtional part after #!ctsdata
-	re := regexp.MustCompile("(?m)[\r\n]*^//.*$") //initialize regex to remove all newlines and carriage returns
-	str = re.ReplaceAllString(str, "")            //remove unnecessary characters
-
-	reader := csv.NewReader(strings.NewReader(str)) //initialize csv reader with str
-	reader.Comma = '#'                              //set # as seperator; sits between URN and respective text
-	reader.LazyQuotes = true
-	reader.FieldsPerRecord = 2 //specifies that each read line will have two fields
+//Returns the node offset places after node, or before it if offset is negative, among the nodes at the same depth. Siblings are counted
+//across the borders of their parents, so the chapter after the last chapter of a book is the first chapter of the next book.
+//Returns false if there is no such node.
+func (work *Work) Sibling(node *CitationNode, offset int) (*CitationNode, bool) {
+	nodes := work.CitationsAt(node.Depth)
+	if offset < -node.Index || offset >= len(nodes)-node.Index {
+		return nil, false
+	}
+	return nodes[node.Index+offset], true
+}
 
-	var response Work //initialize return value (Work)
+//Returns the distinct URNs of the citable nodes at positions first to last truncated to level citation components, in document order.
+//Citable nodes with less components than level are returned as they are.
+func (work *Work) References(first int, last int, level int) []string {
+	var references []string
+	seen := map[string]bool{}
+	for i := first; i <= last; i++ {
+		urn, _ := ParseCTSURN(work.URN[i]) //already validated by ParseWork
+		components := urn.Begin.Components
+		if len(components) > level {
+			components = components[:level]
+		}
+		reference := work.WorkURN + ":" + strings.Join(components, ".")
+		if !seen[reference] {
+			seen[reference] = true
+			references = append(references, reference)
+		}
+	}
+	return references
+}
 
-	for {
-		line, error := reader.Read() //read every line with prepared reader ([]string)
-		if error == io.EOF {         //leave for loop it EOF is reached
-			break
-		} else if error != nil {
-			log.Fatal(error) //log error
+//Returns the citable nodes at positions first to last with their previous and next node and the substrings found by Substrings. Used in ReturnPassage.
+func (work *Work) Nodes(first int, last int, substrings map[int]Substring) []Node {
+	var nodes []Node
+	for i := first; i <= last; i++ {
+		previousnode := ""
+		nextnode := ""
+		if i > 0 {
+			previousnode = work.URN[i-1]
+		}
+		if i < len(work.URN)-1 {
+			nextnode = work.URN[i+1]
 		}
-		response.URN = append(response.URN, line[0])   //add first field of []line to URNs
-		response.Text = append(response.Text, line[1]) //add seconf field of []line to Texts
+		node := Node{URN: []string{work.URN[i]}, Text: []string{work.Text[i]}, Previous: []string{previousnode}, Next: []string{nextnode}, Index: work.Index[i]}
+		if substring, found := substrings[i]; found {
+			node.Subtext = &substring
+		}
+		nodes = append(nodes, node)
 	}
-	clog.Info("Work parsed succesfully")
-	return response
+	return nodes
 }
 
-func ParseCatalog(p CTSParams) Catalog {
-	clog.Info("Parsing catalog")
-	input_file := p.Sourcetext          //get information out of Sourcetext  (string?)
-	data, err := getContent(input_file) //get data out of input_file
-	if err != nil {
-		clog.Error("Parsing Catalog failed. Returning empty catalog")
-		log.Fatal(err)
-		return Catalog{} //return empty Catalog if loading data failed
-	}
-
-	str := string(data) //save data in str
-	//ToDo: add regex that tests for #!ctscatalog 	re :=regexp.MatchString("#!ctscatalog", str)
-	str = strings.Split(str, "#!ctscatalog")[1]   //split data at #!ctscatalog and take the second part
-	str = strings.Split(str, "#!")[0]             // split at #! and take the first part in case there is any other funtional part
-	re := regexp.MustCompile("(?m)[\r\n]*^//.*$") //initialize regex to remove all newlines and carriage returns
-	str = re.ReplaceAllString(str, "")            //remove unnecessary characters
-//	log.Println("String: " + str)
-	reader := csv.NewReader(strings.NewReader(str)) //initialize csv reader with str
-	reader.Comma = '#'                              //set # as seperator; sits between URN and respective text
-	reader.LazyQuotes = true                        //check that
-	reader.FieldsPerRecord = 0                      //specifies that each read line will have as many fields as first line
-	var response Catalog                            //initialize return value (Catalog)
-	for {
-		line, error := reader.Read() //read every line with prepared reader ([]string)
-		if error == io.EOF {         //leave for loop it EOF is reached
+//Returns the character offsets of the subreference of passage in the text of the citable node at position: the start of the
+//token and the position after its last character. The index of the subreference counts occurrences of the token from 1.
+func (work *Work) findSubreference(passage CTSPassage, position int) (int, int, error) {
+	text := work.Text[position]
+	occurrence := passage.Index
+	if occurrence == 0 {
+		occurrence = 1
+	}
+	offset := 0
+	for i := 1; ; i++ {
+		found := strings.Index(text[offset:], passage.Subreference)
+		if found < 0 {
+			return 0, 0, fmt.Errorf("subreference @%v[%v] is not in the text of %v", passage.Subreference, occurrence, work.URN[position])
+		}
+		offset += found
+		if i == occurrence {
 			break
-		} else if error != nil {
-			log.Fatal(error) //log error
-		}
-		var entry CatalogEntry //initialize entry variable to add to Catalog
-		entry.URN = line[0]    //add fields of []line to respective fields of entry
-		entry.CitationScheme = line[1]
-		entry.GroupName = line[2]
-		entry.WorkTitle = line[3]
-		entry.VersionLabel = line[4]
-		entry.ExemplarLabel = line[5]
-		entry.Online = line[6]
-		if reader.FieldsPerRecord == 8 { //This solution will not suffice...
-			entry.Lang = line[7]
-		}
-		if isCTSURN(entry.URN) {
-			response.CatalogEntries = append(response.CatalogEntries, entry)
 		}
+		offset += len(passage.Subreference)
 	}
-	clog.Info("Catalog parsed succesfully")
-	return response
+	start := utf8.RuneCountInString(text[:offset])
+	return start, start + utf8.RuneCountInString(passage.Subreference), nil
 }
 
-//Endpoint Handling Block: contains the handle functions that are executed according to the request.
+//Returns the position in URN of the citable node a subreference of passage points into.
+func (work *Work) subreferencedNode(passage CTSPassage) (int, error) {
+	node, found := work.FindCitation(passage)
+	if !found || node.Position < 0 {
+		return 0, fmt.Errorf("subreference @%v does not point into a citable node", passage.Subreference)
+	}
+	return node.Position, nil
+}
 
-func ReturnCiteVersion(w http.ResponseWriter, r *http.Request) {
-	clog.Info("Called function: ReturnCiteVersion")
-	var result CITEResponse
-	result = CITEResponse{Status: "Success",
-		Service:  "/cite",
-		Versions: Versions{Texts: "1.1.0", Textcatalog: ""}}
-	resultJSON, _ := json.Marshal(result)
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	clog.Info("ReturnCiteVersion executed succesfully")
-	fmt.Fprintln(w, string(resultJSON))
+//Resolves the subreferences of urn for the citable nodes first to last as found by Span. Returns the substrings of the nodes
+//the subreferences cut, keyed by their position in URN, or an empty map if urn has no subreferences.
+//A range may begin and end inside the same node, as long as its end does not come before its beginning.
+func (work *Work) Substrings(urn CTSURN, first int, last int) (map[int]Substring, error) {
+	substrings := map[int]Substring{}
+	begin := urn.RangeBegin()
+	end := urn.RangeEnd()
+	if begin.Begin.Subreference == "" && end.Begin.Subreference == "" {
+		return substrings, nil
+	}
+	firstStart, firstEnd := 0, utf8.RuneCountInString(work.Text[first])
+	lastStart, lastEnd := 0, utf8.RuneCountInString(work.Text[last])
+	if begin.Begin.Subreference != "" {
+		position, err := work.subreferencedNode(begin.Begin)
+		if err != nil {
+			return nil, err
+		}
+		first = position
+		firstStart, firstEnd, err = work.findSubreference(begin.Begin, position)
+		if err != nil {
+			return nil, err
+		}
+		if urn.Range {
+			firstEnd = utf8.RuneCountInString(work.Text[first])
+		}
+	}
+	if urn.Range && end.Begin.Subreference != "" {
+		position, err := work.subreferencedNode(end.Begin)
+		if err != nil {
+			return nil, err
+		}
+		last = position
+		_, lastEnd, err = work.findSubreference(end.Begin, position)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !urn.Range || first == last {
+		start, stop := firstStart, firstEnd
+		if urn.Range {
+			stop = lastEnd
+		}
+		if stop <= start {
+			return nil, fmt.Errorf("range %v ends before it begins", urn.Passage())
+		}
+		substrings[first] = newSubstring(urn.String(), work.Text[first], start, stop)
+		return substrings, nil
+	}
+	if begin.Begin.Subreference != "" {
+		substrings[first] = newSubstring(begin.String(), work.Text[first], firstStart, firstEnd)
+	}
+	if end.Begin.Subreference != "" {
+		substrings[last] = newSubstring(end.String(), work.Text[last], lastStart, lastEnd)
+	}
+	return substrings, nil
 }
 
-func ReturnTextsVersion(w http.ResponseWriter, r *http.Request) {
+//Returns the Substring of text from character start up to character end.
+func newSubstring(urn string, text string, start int, end int) Substring {
+	characters := []rune(text)
+	return Substring{URN: urn, Text: string(characters[start:end]), Start: start, End: end}
+}
+
+//Returns the substrings in the order of the nodes they belong to. Used in ReturnReff.
+func orderedSubstrings(substrings map[int]Substring) []Substring {
+	var positions []int
+	for position := range substrings {
+		positions = append(positions, position)
+	}
+	sort.Ints(positions)
+	var result []Substring
+	for _, position := range positions {
+		result = append(result, substrings[position])
+	}
+	return result
+}
+
+//***CTS URN Block: parses, compares and prints CTS URNs***
+
+//Parses the string s as a CTS URN of the form urn:cts:namespace:textgroup.work.version.exemplar:passage.
+//The passage may be empty, a reference like 1.2, a reference with subreference like 1.2@μῆνις[1], or a range of two of those separated by a hyphen.
+//Subreferences may contain hyphens, see splitRange.
+//Returns CTSURN or an error describing why s is not a valid CTS URN.
+func ParseCTSURN(s string) (CTSURN, error) {
+	var result CTSURN
+	fields := strings.Split(s, ":")
+	switch {
+	case len(fields) < 4: //URN has to have at least 4 parts
+		return result, fmt.Errorf("not enough fields (should be 4 or 5)")
+	case len(fields) > 5: //URN may not have more than 5 parts
+		return result, fmt.Errorf("too many fields (should be 4 or 5)")
+	case fields[0] != "urn": //First field of URN must be "urn"
+		return result, fmt.Errorf("first field must be urn")
+	case fields[1] != "cts": //Second field of URN must be "cts"
+		return result, fmt.Errorf("second field must be cts")
+	case fields[2] == "":
+		return result, fmt.Errorf("namespace is empty")
+	}
+	result.Namespace = fields[2]
+	workParts := strings.Split(fields[3], ".")
+	if len(workParts) > 4 {
+		return result, fmt.Errorf("work component %v has more than four parts", fields[3])
+	}
+	for _, part := range workParts {
+		if part == "" {
+			return result, fmt.Errorf("work component %v has an empty part", fields[3])
+		}
+	}
+	workParts = append(workParts, "", "", "")
+	result.TextGroup, result.Work, result.Version, result.Exemplar = workParts[0], workParts[1], workParts[2], workParts[3]
+	if len(fields) == 4 || fields[4] == "" {
+		return result, nil
+	}
+	ends := splitRange(fields[4])
+	if len(ends) > 2 {
+		return result, fmt.Errorf("passage %v has more than one hyphen", fields[4])
+	}
+	var err error
+	if result.Begin, err = parseCTSPassage(ends[0]); err != nil {
+		return result, err
+	}
+	if len(ends) == 2 {
+		result.Range = true
+		if result.End, err = parseCTSPassage(ends[1]); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+//Splits the passage of a CTS URN at its range hyphen. A hyphen inside a subreference belongs to it, as in 1.1@well-known, unless it is followed
+//by a reference with a subreference of its own, as in 1.2@point-2.1@One. To end a range at a reference without subreference, close the
+//subreference of the begin with its index, as in 1.2@point[1]-2.1.
+func splitRange(passage string) []string {
+	var ends []string
+	start, subreference := 0, false
+	for i, char := range passage {
+		switch {
+		case char == '@':
+			subreference = true
+		case char == ']':
+			subreference = false
+		case char == '-' && (!subreference || rangeEnd(passage[i+1:])):
+			ends = append(ends, passage[start:i])
+			start, subreference = i+1, false
+		}
+	}
+	return append(ends, passage[start:])
+}
+
+//Returns bool for whether rest, the text after a hyphen inside a subreference, is a well-formed passage reference followed by a subreference.
+func rangeEnd(rest string) bool {
+	at := strings.Index(rest, "@")
+	if at < 0 {
+		return false
+	}
+	for _, component := range strings.Split(rest[:at], ".") {
+		if component == "" || strings.ContainsAny(component, "-[]") {
+			return false
+		}
+	}
+	return true
+}
+
+//Parses a passage reference without range, e.g. 1.2 or 1.2@μῆνις[1]. Returns CTSPassage.
+func parseCTSPassage(s string) (CTSPassage, error) {
+	var passage CTSPassage
+	reference := s
+	if at := strings.Index(s, "@"); at >= 0 {
+		reference = s[:at]
+		token := s[at+1:]
+		if open := strings.Index(token, "["); open >= 0 {
+			if !strings.HasSuffix(token, "]") {
+				return passage, fmt.Errorf("subreference %v has no closing bracket", token)
+			}
+			index, err := strconv.Atoi(token[open+1 : len(token)-1])
+			if err != nil || index < 1 {
+				return passage, fmt.Errorf("subreference index in %v must be a positive number", token)
+			}
+			passage.Index = index
+			token = token[:open]
+		}
+		if token == "" {
+			return passage, fmt.Errorf("subreference in %v is empty", s)
+		}
+		passage.Subreference = token
+	}
+	if reference == "" {
+		return passage, fmt.Errorf("passage reference in %v is empty", s)
+	}
+	passage.Components = strings.Split(reference, ".")
+	for _, component := range passage.Components {
+		if component == "" {
+			return passage, fmt.Errorf("passage reference %v has an empty component", reference)
+		}
+	}
+	return passage, nil
+}
+
+//Returns the canonical string of the passage end, e.g. 1.2@μῆνις[1].
+func (passage CTSPassage) String() string {
+	result := strings.Join(passage.Components, ".")
+	if passage.Subreference != "" {
+		result += "@" + passage.Subreference
+		if passage.Index > 0 {
+			result += "[" + strconv.Itoa(passage.Index) + "]"
+		}
+	}
+	return result
+}
+
+//Returns the reference of the passage end without its subreference.
+func (passage CTSPassage) Reference() string {
+	return strings.Join(passage.Components, ".")
+}
+
+//Returns bool for whether the reference of passage equals or lies below the reference of ancestor. Subreferences are ignored.
+func (passage CTSPassage) Below(ancestor CTSPassage) bool {
+	if len(passage.Components) < len(ancestor.Components) {
+		return false
+	}
+	for i := range ancestor.Components {
+		if passage.Components[i] != ancestor.Components[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//Returns the dotted work component of the URN, e.g. tlg0012.tlg001.msA.
+func (urn CTSURN) WorkComponent() string {
+	var parts []string
+	for _, part := range []string{urn.TextGroup, urn.Work, urn.Version, urn.Exemplar} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+//Returns the URN without its passage and without trailing colon, e.g. urn:cts:greekLit:tlg0012.tlg001.msA. Works in a Library are keyed by it.
+func (urn CTSURN) Stem() string {
+	return "urn:cts:" + urn.Namespace + ":" + urn.WorkComponent()
+}
+
+//Returns bool for whether the URN has a passage reference.
+func (urn CTSURN) HasPassage() bool {
+	return len(urn.Begin.Components) > 0
+}
+
+//Returns the passage of the URN, e.g. 1.1-1.10.
+func (urn CTSURN) Passage() string {
+	if urn.Range {
+		return urn.Begin.String() + "-" + urn.End.String()
+	}
+	return urn.Begin.String()
+}
+
+//Returns the canonical string of the URN. URNs without passage end with a colon like in #!ctscatalog.
+func (urn CTSURN) String() string {
+	return urn.Stem() + ":" + urn.Passage()
+}
+
+//Returns a copy of the URN without passage.
+func (urn CTSURN) DropPassage() CTSURN {
+	return CTSURN{Namespace: urn.Namespace, TextGroup: urn.TextGroup, Work: urn.Work, Version: urn.Version, Exemplar: urn.Exemplar}
+}
+
+//Returns a copy of the URN with passage as its only passage reference.
+func (urn CTSURN) WithPassage(passage CTSPassage) CTSURN {
+	result := urn.DropPassage()
+	result.Begin = passage
+	return result
+}
+
+//Returns the first end of a range, or the URN itself if it is not a range.
+func (urn CTSURN) RangeBegin() CTSURN {
+	return urn.WithPassage(urn.Begin)
+}
+
+//Returns the last end of a range, or the URN itself if it is not a range.
+func (urn CTSURN) RangeEnd() CTSURN {
+	if !urn.Range {
+		return urn
+	}
+	return urn.WithPassage(urn.End)
+}
+
+//Returns bool for whether both URNs have the same namespace, text group and work.
+func (urn CTSURN) SameWork(other CTSURN) bool {
+	return urn.Namespace == other.Namespace && urn.TextGroup == other.TextGroup && urn.Work == other.Work
+}
+
+//Returns bool for whether both URNs identify the same version or exemplar, regardless of their passages.
+func (urn CTSURN) SameVersion(other CTSURN) bool {
+	return urn.SameWork(other) && urn.Version == other.Version && urn.Exemplar == other.Exemplar
+}
+
+//Returns bool for whether other is identified by urn: its work hierarchy is at or below the one of urn, and its passage is at or below the passage of urn.
+//The extent of a range depends on document order, so a range only contains what lies below one of its ends; use the citation index of a Work for document order.
+func (urn CTSURN) Contains(other CTSURN) bool {
+	for _, pair := range [][2]string{{urn.TextGroup, other.TextGroup}, {urn.Work, other.Work}, {urn.Version, other.Version}, {urn.Exemplar, other.Exemplar}} {
+		if pair[0] != "" && pair[0] != pair[1] {
+			return false
+		}
+	}
+	if urn.Namespace != other.Namespace {
+		return false
+	}
+	if !urn.HasPassage() {
+		return true
+	}
+	if !other.HasPassage() {
+		return false
+	}
+	for _, end := range []CTSURN{other.RangeBegin(), other.RangeEnd()} {
+		if !end.Begin.Below(urn.Begin) && !(urn.Range && end.Begin.Below(urn.End)) {
+			return false
+		}
+	}
+	return true
+}
+
+//***CITE Collection Block: parses CITE2 URNs and builds the typed objects of CITE collections***
+
+//Parses a CITE2 URN of the form urn:cite2:namespace:collection.version:object, where the version and the object are optional,
+//the collection component may end with a property identifier and the object may be a range. Returns Cite2URN.
+func ParseCite2URN(s string) (Cite2URN, error) {
+	var result Cite2URN
+	parts := strings.Split(s, ":")
+	if len(parts) != 5 || parts[0] != "urn" || parts[1] != "cite2" {
+		return result, fmt.Errorf("%v does not have the form urn:cite2:namespace:collection:object", s)
+	}
+	if parts[2] == "" {
+		return result, fmt.Errorf("%v has no namespace", s)
+	}
+	result.Namespace = parts[2]
+	collection := strings.Split(parts[3], ".")
+	if len(collection) > 3 || collection[0] == "" {
+		return result, fmt.Errorf("collection component %v must have the form collection.version or collection.version.property", parts[3])
+	}
+	result.Collection = collection[0]
+	if len(collection) > 1 {
+		result.Version = collection[1]
+	}
+	if len(collection) > 2 {
+		result.Property = collection[2]
+	}
+	if parts[4] == "" {
+		return result, nil
+	}
+	objects := strings.Split(parts[4], "-")
+	if len(objects) > 2 || objects[0] == "" || (len(objects) == 2 && objects[1] == "") {
+		return result, fmt.Errorf("object %v must be an identifier or a range of two identifiers", parts[4])
+	}
+	result.Begin = objects[0]
+	if len(objects) == 2 {
+		result.End = objects[1]
+		result.Range = true
+	} else if at := strings.Index(result.Begin, "@"); at >= 0 {
+		result.Begin, result.Extension = result.Begin[:at], result.Begin[at+1:]
+	}
+	return result, nil
+}
+
+//Returns the URN of the collection without property and object, e.g. urn:cite2:hmt:msA.v1:
+func (urn Cite2URN) CollectionURN() string {
+	collection := urn.Collection
+	if urn.Version != "" {
+		collection += "." + urn.Version
+	}
+	return "urn:cite2:" + urn.Namespace + ":" + collection + ":"
+}
+
+//Returns the object selector of the URN: an identifier, a range or an empty string.
+func (urn Cite2URN) Object() string {
+	if urn.Range {
+		return urn.Begin + "-" + urn.End
+	}
+	return urn.Begin
+}
+
+//Returns bool for whether the URN identifies objects rather than a whole collection.
+func (urn Cite2URN) HasObject() bool {
+	return urn.Begin != ""
+}
+
+//Returns the URN as a string.
+func (urn Cite2URN) String() string {
+	collection := urn.Collection
+	if urn.Version != "" {
+		collection += "." + urn.Version
+	}
+	if urn.Property != "" {
+		collection += "." + urn.Property
+	}
+	if urn.Extension != "" {
+		return "urn:cite2:" + urn.Namespace + ":" + collection + ":" + urn.Object() + "@" + urn.Extension
+	}
+	return "urn:cite2:" + urn.Namespace + ":" + collection + ":" + urn.Object()
+}
+
+//Converts the value of an object property to the type of the property: string for String and URN types, float64 for Number and bool for Boolean.
+//Empty values are returned as nil. Values of properties with a controlled vocabulary have to be one of its items.
+func typedValue(property CiteProperty, value string) (interface{}, error) {
+	if value == "" {
+		return nil, nil
+	}
+	switch property.Type {
+	case "String":
+		if len(property.Vocabulary) > 0 && !contains(property.Vocabulary, value) {
+			return nil, fmt.Errorf("%v is not in the controlled vocabulary of %v", value, property.URN)
+		}
+		return value, nil
+	case "Number":
+		if !isDecimal(value) {
+			return nil, fmt.Errorf("%v is not a base-ten integer or decimal number", value)
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%v is not a number", value)
+		}
+		return number, nil
+	case "Boolean":
+		switch value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("%v is not true or false", value)
+	case "CtsUrn":
+		if _, err := ParseCTSURN(value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	case "Cite2Urn":
+		if _, err := ParseCite2URN(value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("%v has unknown type %v", property.URN, property.Type)
+}
+
+//Returns bool for whether value is written as the CEX specification allows for numbers: digits with an optional sign and an optional
+//decimal point followed by more digits. ParseFloat also accepts NaN, Inf, hexadecimal numbers and exponents, which CEX does not.
+func isDecimal(value string) bool {
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+		value = value[1:]
+	}
+	parts := strings.SplitN(value, ".", 2) //integer part and decimals
+	digits := func(part string) bool {
+		if part == "" {
+			return false
+		}
+		for _, character := range part {
+			if character < '0' || character > '9' {
+				return false
+			}
+		}
+		return true
+	}
+	return digits(parts[0]) && (len(parts) == 1 || digits(parts[1]))
+}
+
+//Builds the collections declared in #!citecollections with the properties of #!citeproperties and the objects of #!citedata.
+//Objects of ordered collections are sorted by their ordering property. Records with invalid values are skipped and reported.
+//Returns the collections keyed by collection URN and the collection URNs in the order of #!citecollections.
+func BuildCollections(cex CEXData) (map[string]*ObjectCollection, []string, []ParseError) {
+	var diagnostics []ParseError
+	collections := map[string]*ObjectCollection{}
+	var collectionURNs []string
+	for _, definition := range cex.Collections {
+		urn, err := ParseCite2URN(definition.URN)
+		if err != nil {
+			diagnostics = append(diagnostics, ParseError{Source: definition.source, Block: "citecollections", Line: definition.line, Text: definition.URN, Reason: "invalid collection URN: " + err.Error()})
+			continue
+		}
+		collections[urn.CollectionURN()] = &ObjectCollection{Definition: definition, ObjectIndex: map[string]int{}}
+		collectionURNs = append(collectionURNs, urn.CollectionURN())
+	}
+	for _, property := range cex.Properties {
+		urn, err := ParseCite2URN(property.URN)
+		if err != nil || urn.Property == "" {
+			diagnostics = append(diagnostics, ParseError{Source: property.source, Block: "citeproperties", Line: property.line, Text: property.URN, Reason: "property URN must have the form urn:cite2:namespace:collection.version.property:"})
+			continue
+		}
+		collection, found := collections[urn.CollectionURN()]
+		if !found {
+			diagnostics = append(diagnostics, ParseError{Source: property.source, Block: "citeproperties", Line: property.line, Text: property.URN, Reason: "property of a collection missing in #!citecollections"})
+			continue
+		}
+		collection.Properties = append(collection.Properties, property)
+	}
+	for _, dataBlock := range cex.CiteData {
+		for i, record := range dataBlock.Records {
+			line := CEXLine{Number: dataBlock.Lines[i], Text: strings.Join(record, cex.Delimiter)}
+			object, collection, parseError := buildObject(collections, dataBlock.Header, record, line)
+			if parseError != nil {
+				parseError.Source = dataBlock.source
+				diagnostics = append(diagnostics, *parseError)
+				continue
+			}
+			collection.ObjectIndex[object.URN] = len(collection.Objects)
+			collection.Objects = append(collection.Objects, object)
+		}
+	}
+	for _, collection := range collections {
+		if collection.Ordered() {
+			collection.sortObjects()
+		}
+	}
+	return collections, collectionURNs, diagnostics
+}
+
+//Builds the object of a #!citedata record. Columns are matched to properties by their header, ignoring case.
+func buildObject(collections map[string]*ObjectCollection, header []string, record []string, line CEXLine) (CiteObject, *ObjectCollection, *ParseError) {
+	var object CiteObject
+	for i, name := range header {
+		if strings.EqualFold(name, "urn") {
+			object.URN = record[i]
+		}
+	}
+	urn, err := ParseCite2URN(object.URN)
+	if err != nil || !urn.HasObject() || urn.Range || urn.Extension != "" {
+		return object, nil, newParseError("citedata", line, "record has no valid object URN")
+	}
+	collection, found := collections[urn.CollectionURN()]
+	if !found {
+		return object, nil, newParseError("citedata", line, "object of a collection missing in #!citecollections")
+	}
+	if _, duplicate := collection.ObjectIndex[object.URN]; duplicate {
+		return object, nil, newParseError("citedata", line, "duplicate object URN "+object.URN)
+	}
+	for _, property := range collection.Properties {
+		propertyURN, _ := ParseCite2URN(property.URN) //already validated by BuildCollections
+		column := -1
+		for i, name := range header {
+			if strings.EqualFold(name, propertyURN.Property) {
+				column = i
+			}
+		}
+		if column < 0 {
+			return object, nil, newParseError("citedata", line, "no column for property "+property.URN)
+		}
+		value, err := typedValue(property, record[column])
+		if err != nil {
+			return object, nil, newParseError("citedata", line, err.Error())
+		}
+		if property.URN == collection.Definition.LabellingProperty && value != nil {
+			object.Label = fmt.Sprint(value)
+		}
+		valueURN := propertyURN
+		valueURN.Begin = urn.Begin
+		object.Properties = append(object.Properties, PropertyValue{URN: valueURN.String(), Label: property.Label, Type: property.Type, Value: value, property: property.URN})
+	}
+	return object, collection, nil
+}
+
+//Returns bool for whether the collection declares an ordering property.
+func (collection *ObjectCollection) Ordered() bool {
+	return collection.Definition.OrderingProperty != ""
+}
+
+//Sorts the objects by the value of the ordering property and updates ObjectIndex. Objects without a number value keep their order after the others.
+func (collection *ObjectCollection) sortObjects() {
+	sequence := func(object CiteObject) (float64, bool) {
+		for _, property := range object.Properties {
+			if property.property == collection.Definition.OrderingProperty {
+				number, ok := property.Value.(float64)
+				return number, ok
+			}
+		}
+		return 0, false
+	}
+	sort.SliceStable(collection.Objects, func(i, j int) bool {
+		first, firstOk := sequence(collection.Objects[i])
+		second, secondOk := sequence(collection.Objects[j])
+		if firstOk != secondOk {
+			return firstOk
+		}
+		return firstOk && first < second
+	})
+	for i, object := range collection.Objects {
+		collection.ObjectIndex[object.URN] = i
+	}
+}
+
+//Returns the collection of the CITE2 URN and whether it was found in the library. A URN without version matches the first version of the collection.
+func (library *Library) FindCollection(urn Cite2URN) (*ObjectCollection, bool) {
+	if urn.Version != "" {
+		collection, found := library.Collections[urn.CollectionURN()]
+		return collection, found
+	}
+	for _, collectionURN := range library.CollectionURNs {
+		candidate, _ := ParseCite2URN(collectionURN)
+		if candidate.Namespace == urn.Namespace && candidate.Collection == urn.Collection {
+			return library.Collections[collectionURN], true
+		}
+	}
+	return nil, false
+}
+
+//Returns the positions in Objects of the first and last object the URN identifies in the collection: all objects, a single object
+//or, in ordered collections, a range of objects.
+func (collection *ObjectCollection) Span(urn Cite2URN) (int, int, error) {
+	if !urn.HasObject() {
+		return 0, len(collection.Objects) - 1, nil
+	}
+	collectionURN, _ := ParseCite2URN(collection.Definition.URN)
+	urn.Version = collectionURN.Version
+	first, found := collection.ObjectIndex[urn.CollectionURN()+urn.Begin]
+	if !found {
+		return 0, 0, fmt.Errorf("no object %v in %v", urn.Begin, collection.Definition.URN)
+	}
+	if !urn.Range {
+		return first, first, nil
+	}
+	if !collection.Ordered() {
+		return 0, 0, fmt.Errorf("%v is not an ordered collection and has no ranges", collection.Definition.URN)
+	}
+	last, found := collection.ObjectIndex[urn.CollectionURN()+urn.End]
+	if !found {
+		return 0, 0, fmt.Errorf("no object %v in %v", urn.End, collection.Definition.URN)
+	}
+	if last < first {
+		return 0, 0, fmt.Errorf("range %v ends before it begins", urn.Object())
+	}
+	return first, last, nil
+}
+
+//Returns the objects the URN identifies in the collection, as found by Span.
+func (collection *ObjectCollection) Select(urn Cite2URN) ([]CiteObject, error) {
+	first, last, err := collection.Span(urn)
+	if err != nil {
+		return nil, err
+	}
+	return collection.Objects[first : last+1], nil
+}
+
+//Returns bool for whether other is identified by urn: both are in the same collection, urn has no version or the same version as other,
+//and urn has no object or the same object or range as other, with the same extension if urn has one. Like CTSURN.Contains, this does not know the order of objects.
+func (urn Cite2URN) Contains(other Cite2URN) bool {
+	if urn.Namespace != other.Namespace || urn.Collection != other.Collection || (urn.Version != "" && urn.Version != other.Version) {
+		return false
+	}
+	if !urn.HasObject() {
+		return true
+	}
+	return urn.Object() == other.Object() && (urn.Extension == "" || urn.Extension == other.Extension)
+}
+
+//Returns the description of the collection for /collections.
+func (collection *ObjectCollection) Describe() CollectionDescription {
+	return CollectionDescription{CiteCollection: collection.Definition, Ordered: collection.Ordered(), Properties: collection.Properties, Size: len(collection.Objects)}
+}
+
+//***Relations Block: finds the triples of #!relations a URN appears in***
+
+//Returns the relations in which subject or object refer to the CTS or CITE2 URN. If verb is given, only relations with this verb are returned.
+func (library *Library) RelationsOf(urn string, verb string) []Relation {
+	var relations []Relation
+	for _, relation := range library.CEX.Relations {
+		if verb != "" && strings.TrimSuffix(relation.Verb, ":") != strings.TrimSuffix(verb, ":") {
+			continue
+		}
+		if library.Overlaps(urn, relation.Subject) || library.Overlaps(urn, relation.Object) {
+			relations = append(relations, relation)
+		}
+	}
+	return relations
+}
+
+//Returns bool for whether two CTS URNs or two CITE2 URNs identify overlapping texts or objects: one contains the other or, if the library has
+//their version or collection, their ranges share a citable node or object. A URN never overlaps a URN of the other kind.
+func (library *Library) Overlaps(urn string, other string) bool {
+	if ctsURN, err := ParseCTSURN(urn); err == nil {
+		otherURN, err := ParseCTSURN(other)
+		if err != nil {
+			return false
+		}
+		if ctsURN.Contains(otherURN) || otherURN.Contains(ctsURN) {
+			return true
+		}
+		work, found := library.FindWork(ctsURN)
+		if !found || !ctsURN.SameVersion(otherURN) {
+			return false
+		}
+		first, last, spanned := work.Span(ctsURN)
+		otherFirst, otherLast, otherSpanned := work.Span(otherURN)
+		return spanned && otherSpanned && first <= otherLast && otherFirst <= last
+	}
+	cite2URN, err := ParseCite2URN(urn)
+	if err != nil {
+		return false
+	}
+	otherURN, err := ParseCite2URN(other)
+	if err != nil {
+		return false
+	}
+	if cite2URN.Contains(otherURN) || otherURN.Contains(cite2URN) {
+		return true
+	}
+	if cite2URN.Extension != "" && otherURN.Extension != "" && cite2URN.Extension != otherURN.Extension {
+		return false //different regions of the same image
+	}
+	collection, found := library.FindCollection(cite2URN)
+	if !found || cite2URN.Namespace != otherURN.Namespace || cite2URN.Collection != otherURN.Collection {
+		return false
+	}
+	first, last, err := collection.Span(cite2URN)
+	if err != nil {
+		return false
+	}
+	otherFirst, otherLast, err := collection.Span(otherURN)
+	return err == nil && first <= otherLast && otherFirst <= last
+}
+
+//***DSE Block: links text passages, manuscript surfaces and image regions of the collections declared as DSE collections in #!datamodels***
+
+//URN of the DSE data model in #!datamodels, see https://github.com/cite-architecture/dse
+var dseModel = "urn:cite2:cite:datamodels.v1:dse"
+
+//Builds the DSE records from the objects of every collection the #!datamodels blocks declare as DSE collection.
+//The passage, image region and surface of a record are the values of the properties with the identifiers passage, imageroi and surface.
+func BuildDSE(library *Library) ([]DSERecord, []ParseError) {
+	var diagnostics []ParseError
+	var records []DSERecord
+	for _, model := range library.CEX.DataModels {
+		if strings.TrimSuffix(model.Model, ":") != strings.TrimSuffix(dseModel, ":") {
+			continue
+		}
+		collectionURN, err := ParseCite2URN(model.Collection)
+		if err != nil {
+			diagnostics = append(diagnostics, ParseError{Source: model.source, Block: "datamodels", Line: model.line, Text: model.Collection, Reason: "invalid collection URN: " + err.Error()})
+			continue
+		}
+		collection, found := library.FindCollection(collectionURN)
+		if !found {
+			diagnostics = append(diagnostics, ParseError{Source: model.source, Block: "datamodels", Line: model.line, Text: model.Collection, Reason: "DSE collection missing in #!citecollections"})
+			continue
+		}
+		columns := map[string]string{}
+		for _, property := range collection.Properties {
+			propertyURN, _ := ParseCite2URN(property.URN) //already validated by BuildCollections
+			columns[strings.ToLower(propertyURN.Property)] = property.URN
+		}
+		if columns["passage"] == "" || columns["imageroi"] == "" || columns["surface"] == "" {
+			diagnostics = append(diagnostics, ParseError{Source: model.source, Block: "datamodels", Line: model.line, Text: model.Collection, Reason: "DSE collection needs the properties passage, imageroi and surface"})
+			continue
+		}
+		for _, object := range collection.Objects {
+			record := DSERecord{URN: object.URN, Label: object.Label}
+			for _, value := range object.Properties {
+				text, _ := value.Value.(string)
+				switch value.property {
+				case columns["passage"]:
+					record.Passage = text
+				case columns["imageroi"]:
+					record.ImageROI = text
+				case columns["surface"]:
+					record.Surface = text
+				}
+			}
+			records = append(records, record)
+		}
+	}
+	return records, diagnostics
+}
+
+//Returns the DSE records whose passage overlaps the CTS URN, or whose surface or image overlaps the CITE2 URN. Image regions of the URN are compared only if it has one.
+func (library *Library) DSEOf(urn string) []DSERecord {
+	var records []DSERecord
+	for _, record := range library.DSE {
+		if library.Overlaps(urn, record.Passage) || library.Overlaps(urn, record.Surface) || library.Overlaps(urn, record.ImageROI) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+//***ORCA Block: reads analytical exemplar records of the collections declared as ORCA collections in #!datamodels and derives texts from them***
+
+//URN of the ORCA data model in #!datamodels, see https://github.com/cite-architecture/orca
+var orcaModel = "urn:cite2:cite:datamodels.v1:orca"
+
+//Builds the ORCA records from the objects of every collection the #!datamodels blocks declare as ORCA collection and adds an analytical exemplar
+//for every version they analyse to the works of the library. The passage, analysis and deformation of a record are the values of the properties with
+//the identifiers passage (or analyzed), analysis and deformation (or textdeformation).
+//The exemplar is named after the collection: the records of urn:cite2:hmt:tokens.v1: analysing urn:cts:greekLit:tlg0012.tlg001.msA:1.1@μῆνιν
+//make up urn:cts:greekLit:tlg0012.tlg001.msA.tokens:, with one citable node 1.1.1, 1.1.2, ... per record, in the order of the analysed passages.
+func BuildORCA(library *Library) ([]ORCARecord, []ParseError) {
+	var diagnostics []ParseError
+	var records []ORCARecord
+	for _, model := range library.CEX.DataModels {
+		if strings.TrimSuffix(model.Model, ":") != strings.TrimSuffix(orcaModel, ":") {
+			continue
+		}
+		collectionURN, err := ParseCite2URN(model.Collection)
+		if err != nil {
+			diagnostics = append(diagnostics, ParseError{Source: model.source, Block: "datamodels", Line: model.line, Text: model.Collection, Reason: "invalid collection URN: " + err.Error()})
+			continue
+		}
+		collection, found := library.FindCollection(collectionURN)
+		if !found {
+			diagnostics = append(diagnostics, ParseError{Source: model.source, Block: "datamodels", Line: model.line, Text: model.Collection, Reason: "ORCA collection missing in #!citecollections"})
+			continue
+		}
+		columns := map[string]string{}
+		for _, property := range collection.Properties {
+			propertyURN, _ := ParseCite2URN(property.URN) //already validated by BuildCollections
+			switch strings.ToLower(propertyURN.Property) {
+			case "passage", "analyzed":
+				columns["passage"] = property.URN
+			case "analysis":
+				columns["analysis"] = property.URN
+			case "deformation", "textdeformation":
+				columns["deformation"] = property.URN
+			}
+		}
+		if columns["passage"] == "" || columns["analysis"] == "" || columns["deformation"] == "" {
+			diagnostics = append(diagnostics, ParseError{Source: model.source, Block: "datamodels", Line: model.line, Text: model.Collection, Reason: "ORCA collection needs the properties passage, analysis and deformation"})
+			continue
+		}
+		var analysed []ORCARecord
+		for _, object := range collection.Objects {
+			record := ORCARecord{URN: object.URN, Label: object.Label}
+			for _, value := range object.Properties {
+				text, _ := value.Value.(string)
+				switch value.property {
+				case columns["passage"]:
+					record.Passage = text
+				case columns["analysis"]:
+					record.Analysis = text
+				case columns["deformation"]:
+					record.Deformation = text
+				}
+			}
+			analysed = append(analysed, record)
+		}
+		analysed, exemplarErrors := library.addAnalyticalExemplars(collectionURN.Collection, analysed)
+		for _, exemplarError := range exemplarErrors {
+			diagnostics = append(diagnostics, ParseError{Source: model.source, Block: "datamodels", Line: model.line, Text: model.Collection, Reason: exemplarError.Error()})
+		}
+		records = append(records, analysed...)
+	}
+	return records, diagnostics
+}
+
+//Adds the analytical exemplar named exemplar of every version analysed by the records to the works and the catalog of the library.
+//Analyses of one node are numbered in the order of their subreferences in the text. Returns the records in that order with the URN
+//of their exemplar node. Records of passages missing in the library are returned last and without it.
+func (library *Library) addAnalyticalExemplars(exemplar string, records []ORCARecord) ([]ORCARecord, []error) {
+	var problems []error
+	positions := make([]int, len(records))
+	offsets := make([]int, len(records)) //start of the subreference in the text of the node
+	for i, record := range records {
+		positions[i] = -1
+		passage, err := ParseCTSURN(record.Passage)
+		if err != nil || !passage.HasPassage() || passage.Exemplar != "" {
+			problems = append(problems, fmt.Errorf("%v of %v is not a passage of a version", record.Passage, record.URN))
+			continue
+		}
+		work, found := library.FindWork(passage)
+		if !found {
+			problems = append(problems, fmt.Errorf("%v of %v is not in #!ctsdata", record.Passage, record.URN))
+			continue
+		}
+		first, _, spanned := work.Span(passage.RangeBegin())
+		if !spanned {
+			problems = append(problems, fmt.Errorf("%v of %v is not in #!ctsdata", record.Passage, record.URN))
+			continue
+		}
+		positions[i] = first
+		if begin := passage.RangeBegin().Begin; begin.Subreference != "" {
+			if offsets[i], _, err = work.findSubreference(begin, first); err != nil {
+				problems = append(problems, fmt.Errorf("%v of %v: %v", record.Passage, record.URN, err))
+			}
+		}
+	}
+	order := make([]int, len(records))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		switch {
+		case positions[a] < 0 || positions[b] < 0:
+			return positions[a] >= 0 && positions[b] < 0 //unresolved records last
+		case positions[a] != positions[b]:
+			return positions[a] < positions[b]
+		}
+		return offsets[a] < offsets[b]
+	})
+	sequence := map[string]int{}
+	ordered := make([]ORCARecord, 0, len(records))
+	for _, i := range order {
+		if positions[i] < 0 {
+			ordered = append(ordered, records[i])
+			continue
+		}
+		passage, _ := ParseCTSURN(records[i].Passage)
+		node := passage.RangeBegin()
+		node.Exemplar = exemplar
+		reference := node.Begin.Reference()
+		sequence[node.Stem()+":"+reference]++
+		node.Begin = CTSPassage{Components: append(append([]string{}, node.Begin.Components...), strconv.Itoa(sequence[node.Stem()+":"+reference]))}
+		if _, found := library.Works[node.Stem()]; !found {
+			library.catalogAnalyticalExemplar(passage, node, records[i])
+		}
+		library.addNode(node, node.String(), records[i].Deformation, 0, "")
+		records[i].Exemplar = node.String()
+		ordered = append(ordered, records[i])
+	}
+	return ordered, problems
+}
+
+//Adds a catalog entry for the analytical exemplar node belongs to, copied from the entry of the analysed version with an additional citation tier.
+func (library *Library) catalogAnalyticalExemplar(analysed CTSURN, node CTSURN, record ORCARecord) {
+	for _, entry := range library.Catalog.CatalogEntries {
+		if entry.URN != analysed.DropPassage().String() {
+			continue
+		}
+		entry.URN = node.DropPassage().String()
+		entry.ExemplarLabel = "analytical exemplar " + node.Exemplar
+		entry.CitationTiers = append(append([]string{}, entry.CitationTiers...), "analysis")
+		entry.CitationScheme = strings.Join(entry.CitationTiers, library.CEX.SecondaryDelimiter)
+		entry.Online = "true"
+		library.Catalog.CatalogEntries = append(library.Catalog.CatalogEntries, entry)
+		return
+	}
+}
+
+//Returns the ORCA records whose passage overlaps the CTS URN, or whose analysis overlaps the CITE2 URN.
+func (library *Library) ORCAOf(urn string) []ORCARecord {
+	var records []ORCARecord
+	for _, record := range library.ORCA {
+		if library.Overlaps(urn, record.Passage) || library.Overlaps(urn, record.Analysis) || library.Overlaps(urn, record.Exemplar) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+//***Main Block***
+
+//Initializes mux server, loads configuration from config file, sets the serverIP, maps endpoints to respective funtions. Initialises the headers.
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" { //check CEX files instead of serving them
+		os.Exit(Validate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" { //write a part of a CEX file instead of serving it
+		os.Exit(Export(os.Args[2:]))
+	}
+	clog.Info("Starting up local server.")
+	serverIP := registry.Config.Port
+	if _, err := registry.Library(""); err != nil { //load the test source up front; other libraries are loaded on first request
+		clog.Error("Could not load " + registry.Config.TestSource + ": " + err.Error())
+	}
+	if isLocalDirectory(registry.Config.Source) {
+		names, _ := directoryLibraries(registry.Config.Source)
+		clog.Info("Libraries found in " + registry.Config.Source + ": " + strings.Join(names, ", "))
+	}
+	router := mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/cite", ReturnCiteVersion)
+	router.HandleFunc("/texts", ReturnWorkURNS)
+	router.HandleFunc("/texts/version", ReturnTextsVersion)
+	router.HandleFunc("/catalog", ReturnCatalog)
+	router.HandleFunc("/reload", ReturnReload)
+	router.HandleFunc("/consistency", ReturnConsistency)
+	router.HandleFunc("/library", ReturnLibrary)
+	router.HandleFunc("/cex", ReturnCEX)
+	router.HandleFunc("/export", ReturnExport)
+	router.HandleFunc("/texts/first/{URN}", ReturnFirst)
+	router.HandleFunc("/texts/last/{URN}", ReturnLast)
+	router.HandleFunc("/texts/previous/{URN}", ReturnPrev)
+	router.HandleFunc("/texts/next/{URN}", ReturnNext)
+	router.HandleFunc("/texts/urns/{URN}", ReturnReff)
+	router.HandleFunc("/texts/children/{URN}", ReturnChildren)
+	router.HandleFunc("/texts/parent/{URN}", ReturnParent)
+	router.HandleFunc("/texts/ancestors/{URN}", ReturnAncestors)
+	router.HandleFunc("/texts/toc/{URN}", ReturnTOC)
+	router.HandleFunc("/texts/context/{URN}", ReturnContext)
+	router.HandleFunc("/catalog/{URN}", ReturnCatalog)
+	router.HandleFunc("/texts/{URN}", ReturnPassage)
+	router.HandleFunc("/collections", ReturnCollections)
+	router.HandleFunc("/collections/{URN}", ReturnCollections)
+	router.HandleFunc("/objects/{URN}", ReturnObjects)
+	router.HandleFunc("/relations/{URN}", ReturnRelations)
+	router.HandleFunc("/dse/{URN}", ReturnDSE)
+	router.HandleFunc("/orca/{URN}", ReturnORCA)
+	router.HandleFunc("/{CEX}/texts/", ReturnWorkURNS)
+	router.HandleFunc("/{CEX}/catalog/", ReturnCatalog)
+	router.HandleFunc("/{CEX}/reload", ReturnReload)
+	router.HandleFunc("/{CEX}/consistency", ReturnConsistency)
+	router.HandleFunc("/{CEX}/library", ReturnLibrary)
+	router.HandleFunc("/{CEX}/cex", ReturnCEX)
+	router.HandleFunc("/{CEX}/export", ReturnExport)
+	router.HandleFunc("/{CEX}/texts/first/{URN}", ReturnFirst)
+	router.HandleFunc("/{CEX}/texts/last/{URN}", ReturnLast)
+	router.HandleFunc("/{CEX}/texts/previous/{URN}", ReturnPrev)
+	router.HandleFunc("/{CEX}/texts/next/{URN}", ReturnNext)
+	router.HandleFunc("/{CEX}/texts/urns/{URN}", ReturnReff)
+	router.HandleFunc("/{CEX}/texts/children/{URN}", ReturnChildren)
+	router.HandleFunc("/{CEX}/texts/parent/{URN}", ReturnParent)
+	router.HandleFunc("/{CEX}/texts/ancestors/{URN}", ReturnAncestors)
+	router.HandleFunc("/{CEX}/texts/toc/{URN}", ReturnTOC)
+	router.HandleFunc("/{CEX}/texts/context/{URN}", ReturnContext)
+	router.HandleFunc("/{CEX}/catalog/{URN}", ReturnCatalog)
+	router.HandleFunc("/{CEX}/texts/{URN}", ReturnPassage)
+	router.HandleFunc("/{CEX}/collections/", ReturnCollections)
+	router.HandleFunc("/{CEX}/collections/{URN}", ReturnCollections)
+	router.HandleFunc("/{CEX}/objects/{URN}", ReturnObjects)
+	router.HandleFunc("/{CEX}/relations/{URN}", ReturnRelations)
+	router.HandleFunc("/{CEX}/dse/{URN}", ReturnDSE)
+	router.HandleFunc("/{CEX}/orca/{URN}", ReturnORCA)
+	router.HandleFunc("/", ReturnCiteVersion)
+	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type"})
+	originsOk := handlers.AllowedOrigins([]string{os.Getenv("ORIGIN_ALLOWED")})
+	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS"})
+
+	clog.Info("Server is running")
+	clog.Info("Listening at" + serverIP + "...")
+	log.Fatal(http.ListenAndServe(serverIP, handlers.CORS(originsOk, headersOk, methodsOk)(router)))
+}
+
+//Returns bool for whether the source is a local file or directory rather than an HTTP(S) URL.
+func isLocalSource(source string) bool {
+	return !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://")
+}
+
+//Returns the file system path of a local source. Both plain paths and file:// URLs are accepted.
+func localPath(source string) string {
+	return filepath.FromSlash(strings.TrimPrefix(source, "file://"))
+}
+
+//Returns bool for whether the source is a local directory.
+func isLocalDirectory(source string) bool {
+	if !isLocalSource(source) {
+		return false
+	}
+	info, err := os.Stat(localPath(source))
+	return err == nil && info.IsDir()
+}
+
+//Returns the library names of all *.cex files in the local directory source, sorted alphabetically.
+func directoryLibraries(source string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(localPath(source), "*.cex"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), ".cex"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+//Fetches data from the url, which may also be a local file path or a file:// URL. Returns byte slice. Error handling implemented.
+func getContent(url string) ([]byte, error) {
+	if isLocalSource(url) {
+		data, err := ioutil.ReadFile(localPath(url)) //read the whole file into byte slice
+		if err != nil {
+			return nil, fmt.Errorf("Read file: %v", err)
+		}
+		return data, nil
+	}
+	resp, err := http.Get(url) //get response from server
+	if err != nil {
+		return nil, fmt.Errorf("GET error: %v", err) //return in case of GET error
+	}
+	defer resp.Body.Close() //return in case of http status error
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Status error: %v", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(resp.Body) //read response into byte slice
+	if err != nil {                        //return in case of read error
+		return nil, fmt.Errorf("Read body: %v", err)
+	}
+	return data, nil
+}
+
+//***Command Block: the validate and export subcommands work on CEX files without starting the server***
+
+//Runs the validate subcommand: citeMicros validate [-json] [-delimiter d] [-secondary-delimiter d] file.cex ...
+//Every file (or URL) is parsed by all block parsers and cross-checked like a library. Problems are printed with their line numbers,
+//as text or as JSON. Returns the exit code: 0 if no file has problems, 1 if any has, 2 for wrong usage.
+func Validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the reports as JSON")
+	delimiter := flags.String("delimiter", "", "column delimiter; detected if not given")
+	secondaryDelimiter := flags.String("secondary-delimiter", "", "secondary delimiter; detected if not given")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: citeMicros validate [-json] [-delimiter d] [-secondary-delimiter d] file.cex ...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	clog.Level(lumber.FATAL) //problems are reported below, not logged
+	exitCode := 0
+	var reports []ValidationReport
+	for _, file := range flags.Args() {
+		report := ValidationReport{File: file, Diagnostics: []ParseError{}, Inconsistencies: []ParseError{}}
+		library, err := LoadLibrary(strings.TrimSuffix(filepath.Base(file), ".cex"), file, LibraryConfig{Delimiter: *delimiter, SecondaryDelimiter: *secondaryDelimiter})
+		if err != nil {
+			report.Message = err.(*LibraryError).Message //lenient loading only fails if the file cannot be read
+		} else {
+			report.Version = library.CEX.Version
+			report.Diagnostics = append(report.Diagnostics, library.CEX.Diagnostics...)
+			report.Inconsistencies = append(report.Inconsistencies, library.Inconsistencies...)
+		}
+		if report.Message != "" || len(report.Diagnostics) > 0 || len(report.Inconsistencies) > 0 {
+			exitCode = 1
+		}
+		reports = append(reports, report)
+	}
+	if *asJSON {
+		reportJSON, _ := json.MarshalIndent(reports, "", "  ")
+		fmt.Println(string(reportJSON))
+		return exitCode
+	}
+	for _, report := range reports {
+		if report.Message != "" {
+			fmt.Println(report.File + ": could not be read: " + report.Message)
+			continue
+		}
+		problems := append(append([]ParseError{}, report.Diagnostics...), report.Inconsistencies...)
+		sort.SliceStable(problems, func(i, j int) bool {
+			return problems[i].Line < problems[j].Line
+		})
+		for _, problem := range problems {
+			fmt.Println(report.File + ": " + problem.Error())
+		}
+		switch len(problems) {
+		case 0:
+			fmt.Printf("%v: valid (CEX version %v)\n", report.File, CEXData{Version: report.Version}.VersionLabel())
+		default:
+			fmt.Printf("%v: %d problems (CEX version %v)\n", report.File, len(problems), CEXData{Version: report.Version}.VersionLabel())
+		}
+	}
+	return exitCode
+}
+
+//Runs the export subcommand: citeMicros export [-delimiter d] [-secondary-delimiter d] [-o file] source.cex urn ...
+//Writes the part of the source selected by the CTS URNs as CEX to the file or to standard output. Returns the exit code.
+func Export(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	delimiter := flags.String("delimiter", "", "column delimiter of the result; the one of the source if not given")
+	secondaryDelimiter := flags.String("secondary-delimiter", "", "secondary delimiter of the result; the one of the source if not given")
+	output := flags.String("o", "", "file to write the result to instead of standard output")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: citeMicros export [-delimiter d] [-secondary-delimiter d] [-o file] source.cex urn ...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return 2
+	}
+	clog.Level(lumber.FATAL)
+	source := flags.Arg(0)
+	library, err := LoadLibrary(strings.TrimSuffix(filepath.Base(source), ".cex"), source, LibraryConfig{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	text, err := exportSubset(library, flags.Args()[1:], *delimiter, *secondaryDelimiter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if *output == "" {
+		fmt.Print(text)
+		return 0
+	}
+	if err := ioutil.WriteFile(*output, []byte(text), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
+
+//Parses the URNs, selects them from the library and writes the subset as CEX with the given delimiters, or those of the library if they are empty.
+//Used by Export and ReturnExport.
+func exportSubset(library *Library, urns []string, delimiter string, secondaryDelimiter string) (string, error) {
+	var requestCTS []CTSURN
+	for _, urn := range urns {
+		parsed, err := library.ParseCTSURN(urn)
+		if err != nil {
+			return "", fmt.Errorf("%v is not valid CTS: %v", urn, err)
+		}
+		requestCTS = append(requestCTS, parsed)
+	}
+	subset, err := library.Subset(requestCTS)
+	if err != nil {
+		return "", err
+	}
+	if delimiter == "" {
+		delimiter = library.CEX.Delimiter
+	}
+	if secondaryDelimiter == "" {
+		secondaryDelimiter = library.CEX.SecondaryDelimiter
+	}
+	return WriteCEX(subset, delimiter, secondaryDelimiter)
+}
+
+//***Library Block: loads every CEX source once and keeps the parsed data in memory***
+
+//Initializes a LibraryRegistry with the configuration found in configFile. Returns *LibraryRegistry.
+func NewLibraryRegistry(configFile string) *LibraryRegistry {
+	return &LibraryRegistry{ConfigFile: configFile, Config: LoadConfiguration(configFile), Libraries: map[string]*Library{}, Rejected: map[string]*LibraryError{}, loading: map[string]*sync.Mutex{}}
+}
+
+//Returns the location of the CEX source for the library called name. An empty name selects the test source.
+//If the source is a local directory, name must be one of the *.cex files in it.
+func (registry *LibraryRegistry) SourceFor(name string) (string, error) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	switch {
+	case name == "":
+		return registry.Config.TestSource, nil
+	case isLocalDirectory(registry.Config.Source):
+		names, err := directoryLibraries(registry.Config.Source)
+		if err != nil {
+			return "", err
+		}
+		if !contains(names, name) {
+			return "", fmt.Errorf("no library %v.cex in %v", name, registry.Config.Source)
+		}
+		return filepath.Join(localPath(registry.Config.Source), name+".cex"), nil
+	default:
+		return registry.Config.Source + name + ".cex", nil
+	}
+}
+
+//Returns the library called name. The CEX source is only fetched and parsed the first time the library is requested.
+func (registry *LibraryRegistry) Library(name string) (*Library, error) {
+	registry.mutex.RLock()
+	library, found := registry.Libraries[name]
+	registry.mutex.RUnlock()
+	if found {
+		return library, nil
+	}
+	lock := registry.loadLock(name)
+	lock.Lock()
+	defer lock.Unlock()
+	registry.mutex.RLock()
+	library, found = registry.Libraries[name] //another request may have loaded it while we were waiting
+	rejection, rejected := registry.Rejected[name]
+	registry.mutex.RUnlock()
+	switch {
+	case found:
+		return library, nil
+	case rejected:
+		return nil, rejection
+	}
+	return registry.load(name)
+}
+
+//Fetches and parses the source of the library called name again and replaces the stored library.
+//If loading fails the previously loaded library stays in place.
+func (registry *LibraryRegistry) Reload(name string) (*Library, error) {
+	lock := registry.loadLock(name)
+	lock.Lock()
+	defer lock.Unlock()
+	return registry.load(name)
+}
+
+//Returns the lock that guards loading the library called name, creating it on first use.
+func (registry *LibraryRegistry) loadLock(name string) *sync.Mutex {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	lock, found := registry.loading[name]
+	if !found {
+		lock = &sync.Mutex{}
+		registry.loading[name] = lock
+	}
+	return lock
+}
+
+//Reads the configuration file again and reloads every library that has been loaded or rejected so far, each once and in the order of their names.
+//Returns the names of the reloaded libraries.
+func (registry *LibraryRegistry) ReloadAll() ([]string, error) {
+	registry.mutex.Lock()
+	registry.Config = LoadConfiguration(registry.ConfigFile)
+	known := map[string]bool{} //a library rejected after it had been loaded is in both maps
+	for name := range registry.Libraries {
+		known[name] = true
+	}
+	for name := range registry.Rejected {
+		known[name] = true
+	}
+	registry.mutex.Unlock()
+	names := []string{}
+	for name := range known {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var reloaded []string
+	for _, name := range names {
+		if _, err := registry.Reload(name); err != nil {
+			return reloaded, fmt.Errorf("library \"%v\": %v", name, err)
+		}
+		reloaded = append(reloaded, name)
+	}
+	return reloaded, nil
+}
+
+//Returns the sorted names of all loaded libraries.
+func (registry *LibraryRegistry) Names() []string {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	names := []string{}
+	for name := range registry.Libraries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Returns the parsing settings of the library called name: the top level settings of the configuration, overridden by those given for name.
+func (registry *LibraryRegistry) SettingsFor(name string) LibraryConfig {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	settings := registry.Config.LibraryConfig
+	settings.Sources = nil //only libraries listed in "libraries" are composed
+	if override, found := registry.Config.Libraries[name]; found {
+		settings.Sources = override.Sources
+		if override.Delimiter != "" {
+			settings.Delimiter = override.Delimiter
+		}
+		if override.SecondaryDelimiter != "" {
+			settings.SecondaryDelimiter = override.SecondaryDelimiter
+		}
+		if override.Strictness != "" {
+			settings.Strictness = override.Strictness
+		}
+	}
+	return settings
+}
+
+//Loads the library called name and stores it. The caller must hold the lock of name.
+func (registry *LibraryRegistry) load(name string) (*Library, error) {
+	var library *Library
+	settings := registry.SettingsFor(name)
+	switch {
+	case len(settings.Sources) > 0:
+		var sources []string
+		var sourceSettings []LibraryConfig
+		for _, source := range settings.Sources {
+			sourceSettings = append(sourceSettings, registry.SettingsFor(source))
+			if strings.Contains(source, "://") || strings.HasSuffix(source, ".cex") { //location of a CEX file
+				sources = append(sources, source)
+				continue
+			}
+			if len(registry.SettingsFor(source).Sources) > 0 {
+				return nil, fmt.Errorf("library %v composes %v, which is composed itself", name, source)
+			}
+			location, err := registry.SourceFor(source)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, location)
+		}
+		var err error
+		library, err = MergeLibrary(name, sources, sourceSettings, settings)
+		if err != nil {
+			return nil, registry.reject(name, err)
+		}
+	default:
+		source, err := registry.SourceFor(name)
+		if err != nil {
+			return nil, err
+		}
+		library, err = LoadLibrary(name, source, settings)
+		if err != nil {
+			return nil, registry.reject(name, err)
+		}
+	}
+	registry.mutex.Lock()
+	registry.Libraries[name] = library
+	delete(registry.Rejected, name)
+	registry.mutex.Unlock()
+	return library, nil
+}
+
+//Stores err as the reason the library called name is rejected if it lists parse diagnostics, so that it is not parsed again on every request. Returns err.
+func (registry *LibraryRegistry) reject(name string, err error) error {
+	if libraryError, ok := err.(*LibraryError); ok && len(libraryError.Diagnostics) > 0 {
+		registry.mutex.Lock()
+		registry.Rejected[name] = libraryError
+		registry.mutex.Unlock()
+	}
+	return err
+}
+
+//Fetches and parses the CEX source into a Library. Nodes of #!ctsdata are grouped by work URN in document order.
+func LoadLibrary(name string, source string, settings LibraryConfig) (*Library, error) {
+	clog.Info("Loading library \"" + name + "\" from " + source)
+	data, err := getContent(source)
+	if err != nil {
+		return nil, &LibraryError{Source: source, Message: err.Error()}
+	}
+	cex := ParseCEX(CTSParams{Sourcetext: string(data), Delimiter: settings.Delimiter, SecondaryDelimiter: settings.SecondaryDelimiter})
+	return buildLibrary(name, source, cex, nil, settings)
+}
+
+//Fetches and parses the CEX sources of a library composed in config.json and builds one library of their union.
+//Each source is parsed with its own settings. Conflicts between the sources are reported as inconsistencies of the library.
+func MergeLibrary(name string, sources []string, sourceSettings []LibraryConfig, settings LibraryConfig) (*Library, error) {
+	clog.Info("Merging library \"" + name + "\" from " + strings.Join(sources, ", "))
+	var parts []CEXData
+	for i, source := range sources {
+		data, err := getContent(source)
+		if err != nil {
+			return nil, &LibraryError{Source: source, Message: err.Error()}
+		}
+		parts = append(parts, ParseCEX(CTSParams{Sourcetext: string(data), Delimiter: sourceSettings[i].Delimiter, SecondaryDelimiter: sourceSettings[i].SecondaryDelimiter}))
+	}
+	cex, conflicts := MergeCEX(sources, parts)
+	return buildLibrary(name, strings.Join(sources, " + "), cex, conflicts, settings)
+}
+
+//Returns the union of the CEX data parsed from the sources. The #!cexversion and delimiters of the first source are kept, and the first
+//#!citelibrary name, URN and license that a source gives. Reports as conflicts: #!citelibrary names, URNs, licenses or namespace URIs that
+//differ from one given before, catalog entries, collections and properties with the URN of a different earlier one, and node URNs that are
+//already used by an earlier source. Only the first of each is kept.
+func MergeCEX(sources []string, parts []CEXData) (CEXData, []ParseError) {
+	var conflicts []ParseError
+	merged := CEXData{Version: parts[0].Version, Delimiter: parts[0].Delimiter, SecondaryDelimiter: parts[0].SecondaryDelimiter, CiteLibrary: parts[0].CiteLibrary}
+	merged.CiteLibrary.Namespaces = nil
+	conflict := func(source int, block string, line int, text string, reason string) {
+		conflicts = append(conflicts, ParseError{Source: sources[source], Block: block, Line: line, Text: text, Reason: reason})
+	}
+	libraryFields := map[string]int{} //source of each #!citelibrary value that is not empty
+	namespaces := map[string]int{}    //source of each namespace abbreviation
+	catalog := map[string]int{}       //position of each catalog URN in merged.Catalog
+	nodes := map[string]int{}         //source of each node URN
+	collections := map[string]int{}   //position of each collection URN in merged.Collections
+	properties := map[string]int{}    //position of each property URN in merged.Properties
+	catalogSource := map[string]int{} //source of each catalog URN
+	for i, part := range parts {
+		for _, diagnostic := range part.Diagnostics {
+			diagnostic.Source = sources[i]
+			merged.Diagnostics = append(merged.Diagnostics, diagnostic)
+		}
+		merged.Blocks = append(merged.Blocks, part.Blocks...)
+		for _, field := range []struct {
+			name  string
+			known *string
+			value string
+		}{{"name", &merged.CiteLibrary.Name, part.CiteLibrary.Name}, {"urn", &merged.CiteLibrary.URN, part.CiteLibrary.URN}, {"license", &merged.CiteLibrary.License, part.CiteLibrary.License}} {
+			switch {
+			case field.value == "" || field.value == *field.known:
+			case *field.known == "":
+				*field.known = field.value
+				libraryFields[field.name] = i
+			default:
+				conflict(i, "citelibrary", 0, field.value, "library "+field.name+" differs from "+*field.known+" in "+sources[libraryFields[field.name]])
+			}
+		}
+		for _, namespace := range part.CiteLibrary.Namespaces {
+			if first, found := namespaces[namespace.Abbreviation]; found {
+				for _, known := range merged.CiteLibrary.Namespaces {
+					if known.Abbreviation == namespace.Abbreviation && known.URI != namespace.URI {
+						conflict(i, "citelibrary", 0, namespace.URI, "namespace "+namespace.Abbreviation+" is "+known.URI+" in "+sources[first])
+					}
+				}
+				continue
+			}
+			namespaces[namespace.Abbreviation] = i
+			merged.CiteLibrary.Namespaces = append(merged.CiteLibrary.Namespaces, namespace)
+		}
+		for _, entry := range part.Catalog.CatalogEntries {
+			if position, found := catalog[entry.URN]; found {
+				known := merged.Catalog.CatalogEntries[position]
+				if known.CitationScheme != entry.CitationScheme || known.GroupName != entry.GroupName || known.WorkTitle != entry.WorkTitle || known.VersionLabel != entry.VersionLabel ||
+					known.ExemplarLabel != entry.ExemplarLabel || known.Online != entry.Online || known.Lang != entry.Lang {
+					conflict(i, "ctscatalog", entry.line, entry.URN, "catalog entry differs from the one in "+sources[catalogSource[entry.URN]])
+				}
+				continue
+			}
+			catalog[entry.URN] = len(merged.Catalog.CatalogEntries)
+			catalogSource[entry.URN] = i
+			entry.source = sources[i]
+			merged.Catalog.CatalogEntries = append(merged.Catalog.CatalogEntries, entry)
+		}
+		for j, nodeURN := range part.CTSData.URN {
+			if first, found := nodes[nodeURN]; found && first != i { //a node repeated within one source is reported by buildLibrary, as without merging
+				conflict(i, "ctsdata", part.CTSData.lines[j], nodeURN, "node URN is already used in "+sources[first])
+				continue
+			}
+			nodes[nodeURN] = i
+			merged.CTSData.URN = append(merged.CTSData.URN, nodeURN)
+			merged.CTSData.Text = append(merged.CTSData.Text, part.CTSData.Text[j])
+			merged.CTSData.lines = append(merged.CTSData.lines, part.CTSData.lines[j])
+			merged.CTSData.sources = append(merged.CTSData.sources, sources[i])
+		}
+		for _, collection := range part.Collections {
+			if position, found := collections[collection.URN]; found {
+				known := merged.Collections[position]
+				known.line, known.source = collection.line, collection.source
+				if known != collection {
+					conflict(i, "citecollections", collection.line, collection.URN, "collection differs from the one declared before")
+				}
+				continue
+			}
+			collections[collection.URN] = len(merged.Collections)
+			collection.source = sources[i]
+			merged.Collections = append(merged.Collections, collection)
+		}
+		for _, property := range part.Properties {
+			if position, found := properties[property.URN]; found {
+				known := merged.Properties[position]
+				if known.Label != property.Label || known.Type != property.Type || strings.Join(known.Vocabulary, "\n") != strings.Join(property.Vocabulary, "\n") {
+					conflict(i, "citeproperties", property.line, property.URN, "property differs from the one declared before")
+				}
+				continue
+			}
+			properties[property.URN] = len(merged.Properties)
+			property.source = sources[i]
+			merged.Properties = append(merged.Properties, property)
+		}
+		for _, dataBlock := range part.CiteData { //duplicate objects are reported by BuildCollections
+			dataBlock.source = sources[i]
+			merged.CiteData = append(merged.CiteData, dataBlock)
+		}
+		merged.ImageData = append(merged.ImageData, part.ImageData...)
+		merged.Relations = append(merged.Relations, part.Relations...)
+		for _, model := range part.DataModels {
+			model.source = sources[i]
+			merged.DataModels = append(merged.DataModels, model)
+		}
+	}
+	return merged, conflicts
+}
+
+//Builds a Library of the parsed CEX data: works, collections, DSE and ORCA records and the consistency checks. inconsistencies holds problems found before,
+//like conflicts between merged sources. With strict settings the library is rejected if any line could not be parsed.
+func buildLibrary(name string, source string, cex CEXData, inconsistencies []ParseError, settings LibraryConfig) (*Library, error) {
+	library := &Library{Name: name, Source: source, Works: map[string]*Work{}, Loaded: time.Now(), CEX: cex, Inconsistencies: inconsistencies}
+	library.Catalog = Catalog{CatalogEntries: append([]CatalogEntry{}, library.CEX.Catalog.CatalogEntries...)} //analytical exemplars are added to the copy
+	workResult := library.CEX.CTSData
+	for i := range workResult.URN {
+		nodeURN, _ := ParseCTSURN(workResult.URN[i]) //already validated by ParseWork
+		if work, found := library.FindWork(nodeURN); found {
+			if _, duplicate := work.NodeIndex[workResult.URN[i]]; duplicate {
+				library.Inconsistencies = append(library.Inconsistencies, ParseError{Source: workResult.sourceOf(i), Block: "ctsdata", Line: workResult.lines[i], Text: workResult.URN[i], Reason: "duplicate node URN; only the first node is served"})
+				continue
+			}
+		}
+		library.addNode(nodeURN, workResult.URN[i], workResult.Text[i], workResult.lines[i], workResult.sourceOf(i))
+	}
+	var collectionErrors, dseErrors, orcaErrors []ParseError
+	library.Collections, library.CollectionURNs, collectionErrors = BuildCollections(library.CEX)
+	library.DSE, dseErrors = BuildDSE(library)
+	library.ORCA, orcaErrors = BuildORCA(library)
+	library.CEX.Diagnostics = append(library.CEX.Diagnostics, collectionErrors...)
+	library.CEX.Diagnostics = append(library.CEX.Diagnostics, dseErrors...)
+	library.CEX.Diagnostics = append(library.CEX.Diagnostics, orcaErrors...)
+	library.resolveCitationTiers()
+	library.Inconsistencies = append(library.Inconsistencies, library.CheckCatalog()...)
+	for _, diagnostic := range library.CEX.Diagnostics {
+		clog.Warn(source + ": " + diagnostic.Error())
+	}
+	for _, inconsistency := range library.Inconsistencies {
+		clog.Warn(source + ": " + inconsistency.Error())
+	}
+	switch settings.Strictness {
+	case "", "lenient":
+	case "strict":
+		if len(library.CEX.Diagnostics) > 0 {
+			return nil, &LibraryError{Source: source, Message: fmt.Sprintf("%d problems found, the first on %v", len(library.CEX.Diagnostics), library.CEX.Diagnostics[0].Error()), Diagnostics: library.CEX.Diagnostics}
+		}
+	default:
+		clog.Warn("Unknown strictness \"" + settings.Strictness + "\" for library \"" + name + "\". Skipping bad lines")
+	}
+	clog.Info("Library \"" + name + "\" loaded: " + fmt.Sprint(len(library.WorkURNs)) + " works, " + fmt.Sprint(len(library.CollectionURNs)) + " collections, CEX version " + library.CEX.VersionLabel())
+	return library, nil
+}
+
+//Appends a citable node to its work, creating the work if this is its first node. Nodes of a work have to be added in document order.
+//
+//line and source locate the node in the CEX data; source is empty unless the library is merged.
+func (library *Library) addNode(nodeURN CTSURN, urn string, text string, line int, source string) {
+	workURN := nodeURN.Stem()
+	work, found := library.Works[workURN]
+	if !found {
+		work = &Work{WorkURN: workURN, NodeIndex: map[string]int{}}
+		library.Works[workURN] = work
+		library.WorkURNs = append(library.WorkURNs, workURN)
+	}
+	work.NodeIndex[urn] = len(work.URN)
+	work.addCitation(nodeURN.Begin.Components, len(work.URN))
+	work.URN = append(work.URN, urn)
+	work.Text = append(work.Text, text)
+	work.Index = append(work.Index, len(work.URN)) //sequence numbers start with 1
+	work.lines = append(work.lines, line)
+	work.sources = append(work.sources, source)
+}
+
+//Returns the CEX source of the node at position i in a merged library, or an empty string.
+func (work *Work) sourceOf(i int) string {
+	if i < len(work.sources) {
+		return work.sources[i]
+	}
+	return ""
+}
+
+//Cross-checks the catalog against the works of the library. Reports catalogued online works without nodes, works without catalog entry,
+//catalog URNs that do not identify a version or exemplar and nodes whose number of citation levels differs from the tiers of the citationScheme.
+func (library *Library) CheckCatalog() []ParseError {
+	var problems []ParseError
+	catalogued := map[string]CatalogEntry{}
+	for _, entry := range library.Catalog.CatalogEntries {
+		urn, _ := ParseCTSURN(entry.URN) //already validated by ParseCatalog
+		if urn.Version == "" || urn.HasPassage() {
+			problems = append(problems, ParseError{Source: entry.source, Block: "ctscatalog", Line: entry.line, Text: entry.URN, Reason: "not the URN of a version or exemplar"})
+			continue
+		}
+		catalogued[urn.Stem()] = entry
+		if _, found := library.Works[urn.Stem()]; !found && strings.EqualFold(entry.Online, "true") {
+			problems = append(problems, ParseError{Source: entry.source, Block: "ctscatalog", Line: entry.line, Text: entry.URN, Reason: "catalogued as online but has no nodes in #!ctsdata"})
+		}
+	}
+	for _, workURN := range library.WorkURNs {
+		work := library.Works[workURN]
+		entry, found := catalogued[workURN]
+		if !found {
+			problems = append(problems, ParseError{Source: work.sourceOf(0), Block: "ctsdata", Line: work.lines[0], Text: work.URN[0], Reason: "node of " + workURN + ":, which is not in #!ctscatalog"})
+			continue
+		}
+		if entry.CitationScheme == "" {
+			continue
+		}
+		reported := map[int]bool{} //report every wrong depth once per work
+		for i, nodeURN := range work.URN {
+			node, _ := ParseCTSURN(nodeURN)
+			depth := len(node.Begin.Components)
+			if depth != len(entry.CitationTiers) && !reported[depth] {
+				reported[depth] = true
+				problems = append(problems, ParseError{Source: work.sourceOf(i), Block: "ctsdata", Line: work.lines[i], Text: nodeURN, Reason: fmt.Sprintf("%d citation levels, but the citationScheme %v has %d", depth, entry.CitationScheme, len(entry.CitationTiers))})
+			}
+		}
+	}
+	return problems
+}
+
+//Returns the problem as a string for log output and exception messages.
+func (parseError ParseError) Error() string {
+	message := fmt.Sprintf("line %d of #!%s: %s (%q)", parseError.Line, parseError.Block, parseError.Reason, parseError.Text)
+	if parseError.Line == 0 { //problems of the source as a whole, like missing blocks
+		message = fmt.Sprintf("#!%s: %s", parseError.Block, parseError.Reason)
+	}
+	if parseError.Source != "" {
+		return parseError.Source + ": " + message
+	}
+	return message
+}
+
+//Returns the reason the library could not be loaded.
+func (libraryError *LibraryError) Error() string {
+	return libraryError.Source + ": " + libraryError.Message
+}
+
+//Writes an exception response for a request whose library could not be loaded, including the parse diagnostics if there are any.
+func writeLibraryException(w http.ResponseWriter, service string, loadError error) {
+	result := LibraryExceptionResponse{Status: "Exception", Service: service, Message: "Could not load library: " + loadError.Error()}
+	if libraryError, ok := loadError.(*LibraryError); ok {
+		result.Diagnostics = libraryError.Diagnostics
+	}
+	writeJSON(w, result)
+	clog.Error(service + ": " + loadError.Error())
+}
+
+//Returns the publisher information of the library from its #!citelibrary block.
+func (library *Library) Info() LibraryInfo {
+	return LibraryInfo{LibraryURN: library.CEX.CiteLibrary.URN, License: library.CEX.CiteLibrary.License}
+}
+
+//Parses a CTS URN requested from the library. If the #!citelibrary block declares namespaces, the namespace of the URN has to be one of them.
+func (library *Library) ParseCTSURN(s string) (CTSURN, error) {
+	urn, err := ParseCTSURN(s)
+	if err != nil || len(library.CEX.CiteLibrary.Namespaces) == 0 {
+		return urn, err
+	}
+	for _, namespace := range library.CEX.CiteLibrary.Namespaces {
+		if namespace.Abbreviation == urn.Namespace {
+			return urn, nil
+		}
+	}
+	return urn, fmt.Errorf("namespace %v is not declared in the #!citelibrary block of the library", urn.Namespace)
+}
+
+//Stores the citation tiers of the citationScheme of its catalog entry on every work, including the analytical exemplars. The first entry of a work counts.
+func (library *Library) resolveCitationTiers() {
+	resolved := map[string]bool{}
+	for _, entry := range library.Catalog.CatalogEntries {
+		urn, err := ParseCTSURN(entry.URN)
+		if err != nil || resolved[urn.Stem()] {
+			continue
+		}
+		if work, found := library.Works[urn.Stem()]; found {
+			work.Tiers = entry.CitationTiers
+			resolved[urn.Stem()] = true
+		}
+	}
+}
+
+//Returns the name of the citation tier at depth, e.g. book for depth 1 of book,line, or an empty string if the citationScheme has no such tier.
+func tierName(tiers []string, depth int) string {
+	if depth < 1 || depth > len(tiers) {
+		return ""
+	}
+	return tiers[depth-1]
+}
+
+//Returns the container or citable node of the work labelled with its citation tier.
+func (library *Library) Citation(work *Work, node *CitationNode) Citation {
+	citation := Citation{URN: work.CitationURN(node), Tier: tierName(work.Tiers, node.Depth), Depth: node.Depth, Leaf: node.Position >= 0, First: work.URN[node.First], Last: work.URN[node.Last]}
+	if node.Parent == nil { //the root stands for the whole version or exemplar
+		urn, _ := ParseCTSURN(citation.URN)
+		citation.Tier = "version"
+		if urn.Exemplar != "" {
+			citation.Tier = "exemplar"
+		}
+	}
+	return citation
+}
+
+//Returns the table of contents of the container node: its entry with the entries of the containers below it down to depth.
+//Citable nodes are left out.
+func (library *Library) TOC(work *Work, node *CitationNode, depth int) TOCEntry {
+	entry := TOCEntry{Citation: library.Citation(work, node), Leaves: node.Last - node.First + 1}
+	for i := node.First; i <= node.Last; i++ {
+		entry.Characters += utf8.RuneCountInString(work.Text[i])
+		entry.Words += len(strings.Fields(work.Text[i]))
+	}
+	for _, child := range node.Children {
+		if child.Depth <= depth && len(child.Children) > 0 {
+			entry.Children = append(entry.Children, library.TOC(work, child, depth))
+		}
+	}
+	return entry
+}
+
+//Returns the positions in URN of the first and last citable node of the context of the span first to last: count containers before and
+//after it at depth, or count citable nodes if depth is 0. The context ends early at the beginning or end of the work.
+func (work *Work) Context(first int, last int, count int, depth int) (int, int) {
+	if depth == 0 {
+		if count > len(work.URN) { //more than the whole work; also keeps last+count from overflowing
+			count = len(work.URN)
+		}
+		return maxInt(first-count, 0), minInt(last+count, len(work.URN)-1)
+	}
+	begin, end := work.containerAt(first, depth), work.containerAt(last, depth)
+	if begin == nil || end == nil {
+		return first, last
+	}
+	containers := work.CitationsAt(depth)
+	if count > len(containers) {
+		count = len(containers)
+	}
+	return containers[maxInt(begin.Index-count, 0)].First, containers[minInt(end.Index+count, len(containers)-1)].Last
+}
+
+//Returns the container at depth that holds the citable node at position, or nil if the node is not that deep.
+func (work *Work) containerAt(position int, depth int) *CitationNode {
+	node := work.Citation
+	for node != nil && node.Depth < depth {
+		var next *CitationNode
+		for _, child := range node.Children {
+			if child.First <= position && position <= child.Last {
+				next = child
+				break
+			}
+		}
+		node = next
+	}
+	return node
+}
+
+//Returns the response of /texts/next (offset 1) or /texts/previous (offset -1) for a container: the sibling container at the same
+//depth with all its citable nodes, or no nodes if the container is the last or first one.
+func (library *Library) siblingContainer(work *Work, container *CitationNode, offset int, requestURN string) NodeResponse {
+	sibling, found := work.Sibling(container, offset)
+	if !found {
+		return NodeResponse{requestURN: []string{requestURN}, Status: "Success", Tier: tierName(work.Tiers, container.Depth), Nodes: []Node{}}
+	}
+	return NodeResponse{requestURN: []string{requestURN},
+		Status: "Success",
+		URN:    []string{work.CitationURN(sibling)},
+		Tier:   tierName(work.Tiers, sibling.Depth),
+		Nodes:  work.Nodes(sibling.First, sibling.Last, nil)}
+}
+
+//Returns the work (version or exemplar) the CTS URN belongs to and whether it was found in the library.
+func (library *Library) FindWork(urn CTSURN) (*Work, bool) {
+	work, found := library.Works[urn.Stem()]
+	return work, found
+}
+
+//***Parsing Block: contains functions to parse the different parts of the CEX file***
+
+//Labels of the blocks defined in the CEX specification (citedx/doc/CEX-spec-3.1.txt).
+var cexBlockLabels = []string{"cexversion", "citelibrary", "ctsdata", "ctscatalog", "citecollections", "citeproperties", "citedata", "imagedata", "relations", "datamodels"}
+
+//Rules of the supported CEX versions. CEX 3.0 added the lang column to #!ctscatalog; many 2.0 sources carry it already, so it is optional there.
+var cexVersionRules = []CEXRules{
+	{Version: "2.0",
+		CatalogColumns:  [2]int{7, 8},
+		RequiredBlocks:  []string{"citelibrary"},
+		DependentBlocks: map[string][]string{"ctsdata": {"ctscatalog"}}},
+	{Version: "3.x",
+		CatalogColumns: [2]int{8, 8},
+		CatalogHeader:  true,
+		RequiredBlocks: []string{"cexversion", "citelibrary"},
+		DependentBlocks: map[string][]string{
+			"ctsdata":    {"ctscatalog"},
+			"citedata":   {"citecollections", "citeproperties"},
+			"imagedata":  {"citecollections", "citeproperties", "citedata"},
+			"relations":  {"citecollections", "citeproperties", "citedata"},
+			"datamodels": {"citecollections", "citeproperties", "citedata"}}},
+}
+
+//Rules for sources that do not declare their version: catalogs with or without the lang column and no required blocks.
+var undeclaredVersionRules = CEXRules{CatalogColumns: [2]int{7, 8}}
+
+//Column delimiters tried in this order when a library does not declare its delimiter. Other delimiters, including multi-character ones, have to be declared in config.json.
+var candidateDelimiters = []string{"#", "\t", "|", ";", ",", "##", "||", "\t\t"}
+
+//Secondary delimiters tried in this order when a library does not declare its secondary delimiter.
+var candidateSecondaryDelimiters = []string{",", ";", "|", "/", "+"}
+
+//Expected number of columns of the blocks used to detect the column delimiter.
+var delimiterTestColumns = map[string][2]int{"ctsdata": {2, 2}, "ctscatalog": {7, 8}, "relations": {3, 3}, "citelibrary": {2, 3}}
+
+//Returns the candidate delimiter that splits the most content lines of #!ctsdata, #!ctscatalog, #!relations and #!citelibrary into the expected number of columns.
+//Returns # if no line fits any candidate.
+func DetectDelimiter(blocks []CEXBlock) string {
+	best, bestScore := "#", 0
+	for _, candidate := range candidateDelimiters {
+		score := 0
+		for _, block := range blocks {
+			expected, known := delimiterTestColumns[block.Label]
+			if !known {
+				continue
+			}
+			for _, line := range block.Lines {
+				columns := len(strings.Split(line.Text, candidate))
+				if columns >= expected[0] && columns <= expected[1] {
+					score++
+				}
+			}
+		}
+		if score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best
+}
+
+//Returns the first candidate secondary delimiter found in the citation schemes of the #!ctscatalog blocks, which must differ from delimiter.
+//The first line of a block is skipped if the rules require a header line or if it names the urn and citationScheme columns.
+//Returns , if the citation schemes only have one tier.
+func DetectSecondaryDelimiter(blocks []CEXBlock, delimiter string, rules CEXRules) string {
+	for _, candidate := range candidateSecondaryDelimiters {
+		if strings.Contains(delimiter, candidate) {
+			continue
+		}
+		for _, block := range blocksLabelled(blocks, "ctscatalog") {
+			for i, line := range block.Lines {
+				columns := strings.Split(line.Text, delimiter)
+				if i == 0 && (rules.CatalogHeader || catalogHeader(columns)) {
+					continue
+				}
+				if len(columns) > 1 && strings.Contains(columns[1], candidate) {
+					return candidate
+				}
+			}
+		}
+	}
+	return ","
+}
+
+//Returns bool for whether the columns of a #!ctscatalog line are the header names urn and citationScheme, ignoring case.
+func catalogHeader(columns []string) bool {
+	return len(columns) > 1 && strings.EqualFold(strings.TrimSpace(columns[0]), "urn") && strings.EqualFold(strings.TrimSpace(columns[1]), "citationScheme")
+}
+
+//Splits the CEX source into its labelled blocks in the order they appear. Blocks may repeat.
+//Content preceding the first block, empty lines and lines beginning with // are dropped.
+func SplitCEXBlocks(sourcetext string) []CEXBlock {
+	var blocks []CEXBlock
+	for i, text := range strings.Split(sourcetext, "\n") {
+		text = strings.TrimRight(text, "\r")
+		switch {
+		case strings.HasPrefix(text, "#!"): //a block label introduces a new block
+			blocks = append(blocks, CEXBlock{Label: strings.TrimSpace(strings.TrimPrefix(text, "#!")), Line: i + 1})
+		case strings.TrimSpace(text) == "" || strings.HasPrefix(text, "//"):
+			continue
+		case len(blocks) == 0: //content preceding the first labelled block is ignored
+			continue
+		default:
+			block := &blocks[len(blocks)-1]
+			block.Lines = append(block.Lines, CEXLine{Number: i + 1, Text: text})
+		}
+	}
+	return blocks
+}
+
+//Returns all blocks with the given label in source order.
+func blocksLabelled(blocks []CEXBlock, label string) []CEXBlock {
+	var result []CEXBlock
+	for _, block := range blocks {
+		if block.Label == label {
+			result = append(result, block)
+		}
+	}
+	return result
+}
+
+//Returns a ParseError for line of the block labelled label.
+func newParseError(label string, line CEXLine, reason string) *ParseError {
+	return &ParseError{Block: label, Line: line.Number, Text: line.Text, Reason: reason}
+}
+
+//Splits a content line of the block labelled label at the column delimiter of p. Missing optional columns up to max are returned empty.
+//Returns a ParseError if the line has less than min or more than max columns.
+func cexColumns(p CTSParams, label string, line CEXLine, min int, max int) ([]string, *ParseError) {
+	columns := strings.Split(line.Text, p.Delimiter)
+	if len(columns) < min || len(columns) > max {
+		if min == max {
+			return nil, newParseError(label, line, fmt.Sprintf("expected %d columns, found %d", min, len(columns)))
+		}
+		return nil, newParseError(label, line, fmt.Sprintf("expected %d to %d columns, found %d", min, max, len(columns)))
+	}
+	for len(columns) < max {
+		columns = append(columns, "")
+	}
+	return columns, nil
+}
+
+//Parses all blocks of the CEX source in p with the delimiters of p, detecting those that are not given. Returns CEXData.
+func ParseCEX(p CTSParams) CEXData {
+	clog.Info("Parsing CEX blocks")
+	var cex CEXData
+	cex.Blocks = SplitCEXBlocks(p.Sourcetext)
+	if p.Delimiter == "" {
+		p.Delimiter = DetectDelimiter(cex.Blocks)
+	}
+	cex.Version = ParseCEXVersion(cex.Blocks)
+	rules, versionError := RulesFor(cex.Version)
+	if versionError != nil {
+		versionError.Line = blocksLabelled(cex.Blocks, "cexversion")[0].Line
+		cex.Diagnostics = append(cex.Diagnostics, *versionError)
+	}
+	p.Rules = rules
+	if p.SecondaryDelimiter == "" {
+		p.SecondaryDelimiter = DetectSecondaryDelimiter(cex.Blocks, p.Delimiter, rules) //the rules tell whether the catalog begins with a header
+	}
+	cex.Delimiter, cex.SecondaryDelimiter = p.Delimiter, p.SecondaryDelimiter
+	clog.Info("Using column delimiter \"" + p.Delimiter + "\" and secondary delimiter \"" + p.SecondaryDelimiter + "\"")
+	cex.Diagnostics = append(cex.Diagnostics, CheckBlocks(cex.Blocks, rules)...)
+	for _, block := range cex.Blocks {
+		if !contains(cexBlockLabels, block.Label) {
+			cex.Diagnostics = append(cex.Diagnostics, ParseError{Block: block.Label, Line: block.Line, Text: "#!" + block.Label, Reason: "unknown block label; block ignored"})
+		}
+	}
+	var libraryErrors, catalogErrors, workErrors, collectionErrors, propertyErrors, dataErrors, imageErrors, relationErrors, modelErrors []ParseError
+	cex.CiteLibrary, libraryErrors = ParseCiteLibrary(cex.Blocks, p)
+	cex.Catalog, catalogErrors = ParseCatalog(cex.Blocks, p)
+	cex.CTSData, workErrors = ParseWork(cex.Blocks, p)
+	cex.Collections, collectionErrors = ParseCiteCollections(cex.Blocks, p)
+	cex.Properties, propertyErrors = ParseCiteProperties(cex.Blocks, p)
+	cex.CiteData, dataErrors = ParseCiteData(cex.Blocks, p)
+	cex.ImageData, imageErrors = ParseImageData(cex.Blocks, p)
+	cex.Relations, relationErrors = ParseRelations(cex.Blocks, p)
+	cex.DataModels, modelErrors = ParseDataModels(cex.Blocks, p)
+	for _, diagnostics := range [][]ParseError{libraryErrors, catalogErrors, workErrors, collectionErrors, propertyErrors, dataErrors, imageErrors, relationErrors, modelErrors} {
+		cex.Diagnostics = append(cex.Diagnostics, diagnostics...)
+	}
+	clog.Info(fmt.Sprintf("Parsed %d CEX blocks", len(cex.Blocks)))
+	return cex
+}
+
+//Returns the declared CEX version, or "undeclared" if the source has no #!cexversion block.
+func (cex CEXData) VersionLabel() string {
+	if cex.Version == "" {
+		return "undeclared"
+	}
+	return cex.Version
+}
+
+//Returns the version string of the #!cexversion block, or an empty string if there is none.
+func ParseCEXVersion(blocks []CEXBlock) string {
+	for _, block := range blocksLabelled(blocks, "cexversion") {
+		for _, line := range block.Lines {
+			return strings.TrimSpace(line.Text) //the block has a single content line
+		}
+	}
+	return ""
+}
+
+//Returns the rules of the CEX version. Versions before 3.0 get the rules of 2.0. Unsupported versions are parsed with the rules of 3.x and
+//reported with a ParseError.
+func RulesFor(version string) (CEXRules, *ParseError) {
+	if version == "" {
+		return undeclaredVersionRules, nil
+	}
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	switch {
+	case err == nil && major <= 2:
+		return cexVersionRules[0], nil
+	case err == nil && major == 3:
+		return cexVersionRules[1], nil
+	}
+	return cexVersionRules[1], &ParseError{Block: "cexversion", Text: version, Reason: "unsupported CEX version; parsed with the rules of CEX " + cexVersionRules[1].Version}
+}
+
+//Returns a ParseError for every block the rules require that is missing in blocks.
+func CheckBlocks(blocks []CEXBlock, rules CEXRules) []ParseError {
+	var diagnostics []ParseError
+	present := map[string]bool{}
+	for _, block := range blocks {
+		present[block.Label] = true
+	}
+	for _, label := range rules.RequiredBlocks {
+		if !present[label] {
+			diagnostics = append(diagnostics, ParseError{Block: label, Reason: "CEX " + rules.Version + " requires a #!" + label + " block"})
+		}
+	}
+	for _, label := range cexBlockLabels { //in a fixed order
+		if !present[label] {
+			continue
+		}
+		for _, required := range rules.DependentBlocks[label] {
+			if !present[required] {
+				diagnostics = append(diagnostics, ParseError{Block: required, Reason: "CEX " + rules.Version + " requires a #!" + required + " block in sources with a #!" + label + " block"})
+			}
+		}
+	}
+	return diagnostics
+}
+
+//Parses the key-value pairs and namespace definitions of the #!citelibrary block.
+func ParseCiteLibrary(blocks []CEXBlock, p CTSParams) (CiteLibrary, []ParseError) {
+	var diagnostics []ParseError
+	var library CiteLibrary
+	for _, block := range blocksLabelled(blocks, "citelibrary") {
+		for _, line := range block.Lines {
+			columns, parseError := cexColumns(p, block.Label, line, 2, 3)
+			if parseError != nil {
+				diagnostics = append(diagnostics, *parseError)
+				continue
+			}
+			switch columns[0] {
+			case "name":
+				library.Name = columns[1]
+			case "urn":
+				library.URN = columns[1]
+			case "license":
+				library.License = columns[1]
+			case "namespace":
+				library.Namespaces = append(library.Namespaces, Namespace{Abbreviation: columns[1], URI: columns[2]})
+			default:
+				diagnostics = append(diagnostics, *newParseError(block.Label, line, "unknown key "+columns[0]))
+			}
+		}
+	}
+	return library, diagnostics
+}
+
+//ParseWork extracts the URNs and texts of all #!ctsdata blocks.
+func ParseWork(blocks []CEXBlock, p CTSParams) (Work, []ParseError) {
+	clog.Info("Parsing work")
+	var response Work //initialize return value (Work)
+	var diagnostics []ParseError
+	for _, block := range blocksLabelled(blocks, "ctsdata") {
+		for _, line := range block.Lines {
+			columns, parseError := cexColumns(p, block.Label, line, 2, 2) //URN and respective text
+			if parseError != nil {
+				diagnostics = append(diagnostics, *parseError)
+				continue
+			}
+			if nodeURN, err := ParseCTSURN(columns[0]); err != nil || !nodeURN.HasPassage() || nodeURN.Range {
+				diagnostics = append(diagnostics, *newParseError(block.Label, line, "not a CTS URN of a single citable node: "+columns[0]))
+				continue
+			}
+			response.URN = append(response.URN, columns[0])
+			response.Text = append(response.Text, columns[1])
+			response.lines = append(response.lines, line.Number)
+		}
+	}
+	clog.Info("Work parsed succesfully")
+	return response, diagnostics
+}
+
+//Parses all #!ctscatalog blocks with the column counts of p.Rules. The first content line of each block is a header and is skipped, unless it is
+//a catalog entry. Such entries are reported if the rules require a header line.
+func ParseCatalog(blocks []CEXBlock, p CTSParams) (Catalog, []ParseError) {
+	var diagnostics []ParseError
+	clog.Info("Parsing catalog")
+	var response Catalog //initialize return value (Catalog)
+	for _, block := range blocksLabelled(blocks, "ctscatalog") {
+		for i, line := range block.Lines {
+			if i == 0 { //header line
+				if _, err := ParseCTSURN(strings.Split(line.Text, p.Delimiter)[0]); err != nil {
+					continue
+				}
+				if p.Rules.CatalogHeader {
+					diagnostics = append(diagnostics, *newParseError(block.Label, line, "expected a header line; CEX "+p.Rules.Version+" catalogs begin with one"))
+				}
+			}
+			columns, parseError := cexColumns(p, block.Label, line, p.Rules.CatalogColumns[0], p.Rules.CatalogColumns[1]) //the lang column was added in CEX 3.0
+			if parseError != nil {
+				diagnostics = append(diagnostics, *parseError)
+				continue
+			}
+			var entry CatalogEntry //initialize entry variable to add to Catalog
+			entry.URN = columns[0] //add columns to respective fields of entry
+			entry.CitationScheme = columns[1]
+			entry.GroupName = columns[2]
+			entry.WorkTitle = columns[3]
+			entry.VersionLabel = columns[4]
+			entry.ExemplarLabel = columns[5]
+			entry.Online = columns[6]
+			entry.Lang = columns[7]
+			entry.CitationTiers = strings.Split(entry.CitationScheme, p.SecondaryDelimiter)
+			entry.line = line.Number
+			if _, err := ParseCTSURN(entry.URN); err != nil {
+				diagnostics = append(diagnostics, *newParseError(block.Label, line, "not a CTS URN: "+err.Error()))
+				continue
+			}
+			response.CatalogEntries = append(response.CatalogEntries, entry)
+		}
+	}
+	clog.Info("Catalog parsed succesfully")
+	return response, diagnostics
+}
+
+//Parses all #!citecollections blocks. The first content line of each block is a header and is skipped.
+func ParseCiteCollections(blocks []CEXBlock, p CTSParams) ([]CiteCollection, []ParseError) {
+	var diagnostics []ParseError
+	var collections []CiteCollection
+	for _, block := range blocksLabelled(blocks, "citecollections") {
+		for i, line := range block.Lines {
+			if i == 0 {
+				continue
+			}
+			columns, parseError := cexColumns(p, block.Label, line, 5, 5)
+			if parseError != nil {
+				diagnostics = append(diagnostics, *parseError)
+				continue
+			}
+			collections = append(collections, CiteCollection{URN: columns[0], Description: columns[1], LabellingProperty: columns[2], OrderingProperty: columns[3], License: columns[4], line: line.Number})
+		}
+	}
+	return collections, diagnostics
+}
+
+//Parses all #!citeproperties blocks. The first content line of each block is a header and is skipped.
+func ParseCiteProperties(blocks []CEXBlock, p CTSParams) ([]CiteProperty, []ParseError) {
+	var diagnostics []ParseError
+	var properties []CiteProperty
+	for _, block := range blocksLabelled(blocks, "citeproperties") {
+		for i, line := range block.Lines {
+			if i == 0 {
+				continue
+			}
+			columns, parseError := cexColumns(p, block.Label, line, 3, 4) //the controlled vocabulary is optional
+			if parseError != nil {
+				diagnostics = append(diagnostics, *parseError)
+				continue
+			}
+			property := CiteProperty{URN: columns[0], Label: columns[1], Type: columns[2], line: line.Number}
+			if columns[3] != "" {
+				property.Vocabulary = strings.Split(columns[3], p.SecondaryDelimiter)
+			}
+			properties = append(properties, property)
+		}
+	}
+	return properties, diagnostics
+}
+
+//Parses all #!citedata blocks. Each block holds the objects of one collection; its first content line names the property of each column.
+func ParseCiteData(blocks []CEXBlock, p CTSParams) ([]CiteDataBlock, []ParseError) {
+	var diagnostics []ParseError
+	var data []CiteDataBlock
+	for _, block := range blocksLabelled(blocks, "citedata") {
+		if len(block.Lines) == 0 {
+			continue
+		}
+		dataBlock := CiteDataBlock{Header: strings.Split(block.Lines[0].Text, p.Delimiter)}
+		for _, line := range block.Lines[1:] {
+			columns, parseError := cexColumns(p, block.Label, line, len(dataBlock.Header), len(dataBlock.Header))
+			if parseError != nil {
+				diagnostics = append(diagnostics, *parseError)
+				continue
+			}
+			dataBlock.Records = append(dataBlock.Records, columns)
+			dataBlock.Lines = append(dataBlock.Lines, line.Number)
+		}
+		data = append(data, dataBlock)
+	}
+	return data, diagnostics
+}
+
+//Parses all #!imagedata blocks.
+func ParseImageData(blocks []CEXBlock, p CTSParams) ([]ImageExtension, []ParseError) {
+	var diagnostics []ParseError
+	var images []ImageExtension
+	for _, block := range blocksLabelled(blocks, "imagedata") {
+		for _, line := range block.Lines {
+			columns, parseError := cexColumns(p, block.Label, line, 2, 4) //base URL and license are missing for some local protocols
+			if parseError != nil {
+				diagnostics = append(diagnostics, *parseError)
+				continue
+			}
+			images = append(images, ImageExtension{Collection: columns[0], Protocol: columns[1], BaseURL: columns[2], License: columns[3]})
+		}
+	}
+	return images, diagnostics
+}
+
+//Parses all #!relations blocks.
+func ParseRelations(blocks []CEXBlock, p CTSParams) ([]Relation, []ParseError) {
+	var diagnostics []ParseError
+	var relations []Relation
+	for _, block := range blocksLabelled(blocks, "relations") {
+		for _, line := range block.Lines {
+			columns, parseError := cexColumns(p, block.Label, line, 3, 3)
+			if parseError != nil {
+				diagnostics = append(diagnostics, *parseError)
+				continue
+			}
+			relations = append(relations, Relation{Subject: columns[0], Verb: columns[1], Object: columns[2]})
+		}
+	}
+	return relations, diagnostics
+}
+
+//Parses all #!datamodels blocks. The first content line of each block is a header and is skipped.
+func ParseDataModels(blocks []CEXBlock, p CTSParams) ([]DataModel, []ParseError) {
+	var diagnostics []ParseError
+	var models []DataModel
+	for _, block := range blocksLabelled(blocks, "datamodels") {
+		for i, line := range block.Lines {
+			if i == 0 {
+				continue
+			}
+			columns, parseError := cexColumns(p, block.Label, line, 4, 4)
+			if parseError != nil {
+				diagnostics = append(diagnostics, *parseError)
+				continue
+			}
+			models = append(models, DataModel{Collection: columns[0], Model: columns[1], Label: columns[2], Description: columns[3], line: line.Number})
+		}
+	}
+	return models, diagnostics
+}
+
+//***Writing Block: serializes parsed CEX data back to CEX***
+
+//Collects the lines of a CEX source. The first value containing the delimiter, the secondary delimiter (in lists) or a line break is kept in err.
+type cexWriter struct {
+	text               strings.Builder
+	delimiter          string
+	secondaryDelimiter string
+	err                error
+}
+
+//Writes a block label line.
+func (writer *cexWriter) label(label string) {
+	if writer.text.Len() > 0 {
+		writer.text.WriteString("\n")
+	}
+	writer.text.WriteString("#!" + label + "\n")
+}
+
+//Writes a content line of columns separated by the delimiter.
+func (writer *cexWriter) line(columns ...string) {
+	for _, column := range columns {
+		if writer.err == nil && (strings.Contains(column, writer.delimiter) || strings.ContainsAny(column, "\r\n")) {
+			writer.err = fmt.Errorf("value %q contains the delimiter %q or a line break", column, writer.delimiter)
+		}
+	}
+	writer.text.WriteString(strings.Join(columns, writer.delimiter) + "\n")
+}
+
+//Returns the items joined with the secondary delimiter.
+func (writer *cexWriter) list(items []string) string {
+	for _, item := range items {
+		if writer.err == nil && strings.Contains(item, writer.secondaryDelimiter) {
+			writer.err = fmt.Errorf("list item %q contains the secondary delimiter %q", item, writer.secondaryDelimiter)
+		}
+	}
+	return strings.Join(items, writer.secondaryDelimiter)
+}
+
+//Writes the CEX data as canonical CEX with the given delimiters: the blocks cexversion, citelibrary, ctscatalog, ctsdata, citecollections,
+//citeproperties, citedata, imagedata, relations and datamodels in this order, each with its header line where the specification requires one,
+//nodes and records in their original order, and without comments or empty blocks. #!cexversion is left out if the source has none, so
+//the result is parsed with the same version rules as the source.
+//Returns an error if a value contains a delimiter or a line break, because the result could not be parsed back.
+func WriteCEX(cex CEXData, delimiter string, secondaryDelimiter string) (string, error) {
+	if delimiter == "" || secondaryDelimiter == "" || delimiter == secondaryDelimiter {
+		return "", fmt.Errorf("delimiter %q and secondary delimiter %q must be different and not empty", delimiter, secondaryDelimiter)
+	}
+	writer := &cexWriter{delimiter: delimiter, secondaryDelimiter: secondaryDelimiter}
+	if cex.Version != "" {
+		writer.text.WriteString("#!cexversion\n" + cex.Version + "\n")
+	}
+	if cex.CiteLibrary.Name != "" || cex.CiteLibrary.URN != "" || cex.CiteLibrary.License != "" || len(cex.CiteLibrary.Namespaces) > 0 {
+		writer.label("citelibrary")
+		writer.line("name", cex.CiteLibrary.Name)
+		writer.line("urn", cex.CiteLibrary.URN)
+		writer.line("license", cex.CiteLibrary.License)
+		for _, namespace := range cex.CiteLibrary.Namespaces {
+			writer.line("namespace", namespace.Abbreviation, namespace.URI)
+		}
+	}
+	if len(cex.Catalog.CatalogEntries) > 0 {
+		writer.label("ctscatalog")
+		writer.line("urn", "citationScheme", "groupName", "workTitle", "versionLabel", "exemplarLabel", "online", "lang")
+		for _, entry := range cex.Catalog.CatalogEntries {
+			writer.line(entry.URN, writer.list(entry.CitationTiers), entry.GroupName, entry.WorkTitle, entry.VersionLabel, entry.ExemplarLabel, entry.Online, entry.Lang)
+		}
+	}
+	if len(cex.CTSData.URN) > 0 {
+		writer.label("ctsdata")
+		for i := range cex.CTSData.URN {
+			writer.line(cex.CTSData.URN[i], cex.CTSData.Text[i])
+		}
+	}
+	if len(cex.Collections) > 0 {
+		writer.label("citecollections")
+		writer.line("URN", "Description", "Labelling property", "Ordering property", "License")
+		for _, collection := range cex.Collections {
+			writer.line(collection.URN, collection.Description, collection.LabellingProperty, collection.OrderingProperty, collection.License)
+		}
+	}
+	if len(cex.Properties) > 0 {
+		writer.label("citeproperties")
+		writer.line("Property", "Label", "Type", "Authority list")
+		for _, property := range cex.Properties {
+			writer.line(property.URN, property.Label, property.Type, writer.list(property.Vocabulary))
+		}
+	}
+	for _, dataBlock := range cex.CiteData {
+		writer.label("citedata")
+		writer.line(dataBlock.Header...)
+		for _, record := range dataBlock.Records {
+			writer.line(record...)
+		}
+	}
+	if len(cex.ImageData) > 0 {
+		writer.label("imagedata")
+		for _, image := range cex.ImageData {
+			writer.line(image.Collection, image.Protocol, image.BaseURL, image.License)
+		}
+	}
+	if len(cex.Relations) > 0 {
+		writer.label("relations")
+		for _, relation := range cex.Relations {
+			writer.line(relation.Subject, relation.Verb, relation.Object)
+		}
+	}
+	if len(cex.DataModels) > 0 {
+		writer.label("datamodels")
+		writer.line("Collection", "Model", "Label", "Description")
+		for _, model := range cex.DataModels {
+			writer.line(model.Collection, model.Model, model.Label, model.Description)
+		}
+	}
+	if writer.err != nil {
+		return "", writer.err
+	}
+	return writer.text.String(), nil
+}
+
+//Returns the part of the library the CTS URNs select as CEX data of its own: the #!ctsdata nodes of the works, passages and ranges work by work
+//in document order, the #!ctscatalog rows of their versions and exemplars and the #!relations triples that refer to them, along with #!cexversion and #!citelibrary.
+//Every node is exported once, even if the source repeats it, and analytical exemplars derived from ORCA collections are exported as ordinary texts.
+//The objects the relations refer to are exported with their collections and properties; relations none of whose CITE2 URNs
+//are in a collection of the library are left out, because the result would lack the collection blocks CEX requires for #!relations.
+func (library *Library) Subset(urns []CTSURN) (CEXData, error) {
+	subset := CEXData{Version: library.CEX.Version, CiteLibrary: library.CEX.CiteLibrary, Delimiter: library.CEX.Delimiter, SecondaryDelimiter: library.CEX.SecondaryDelimiter}
+	selected := map[string]bool{} //node URNs
+	works := map[string]bool{}    //work URNs
+	for _, urn := range urns {
+		work, found := library.FindWork(urn)
+		if !found {
+			return subset, fmt.Errorf("%v is not in the library", urn.String())
+		}
+		first, last, spanned := work.Span(urn)
+		if !spanned {
+			return subset, fmt.Errorf("could not find %v in %v", urn.Passage(), work.WorkURN)
+		}
+		for _, nodeURN := range work.URN[first : last+1] {
+			selected[nodeURN] = true
+		}
+		works[work.WorkURN] = true
+	}
+	for _, entry := range library.Catalog.CatalogEntries { //includes the entries of analytical exemplars
+		if urn, err := ParseCTSURN(entry.URN); err == nil && works[urn.Stem()] {
+			subset.Catalog.CatalogEntries = append(subset.Catalog.CatalogEntries, entry)
+		}
+	}
+	for _, workURN := range library.WorkURNs { //the nodes of a work are unique and include the derived nodes of analytical exemplars
+		work := library.Works[workURN]
+		for i, nodeURN := range work.URN {
+			if selected[nodeURN] {
+				subset.CTSData.URN = append(subset.CTSData.URN, nodeURN)
+				subset.CTSData.Text = append(subset.CTSData.Text, work.Text[i])
+			}
+		}
+	}
+	objects := map[string]map[string]bool{} //URNs of the objects the relations refer to, keyed by collection URN
+	for _, relation := range library.CEX.Relations {
+		for _, urn := range urns {
+			if library.Overlaps(urn.String(), relation.Subject) || library.Overlaps(urn.String(), relation.Object) {
+				if library.referencedObjects(relation, objects) {
+					subset.Relations = append(subset.Relations, relation)
+				}
+				break
+			}
+		}
+	}
+	library.subsetCollections(&subset, objects)
+	return subset, nil
+}
+
+//Adds the objects the CITE2 URNs of the relation identify to objects, keyed by the URN of their collection. Returns false if none of
+//its CITE2 URNs is in a collection of the library.
+func (library *Library) referencedObjects(relation Relation, objects map[string]map[string]bool) bool {
+	resolved := false
+	for _, value := range []string{relation.Subject, relation.Verb, relation.Object} {
+		urn, err := ParseCite2URN(value)
+		if err != nil {
+			continue
+		}
+		collection, found := library.FindCollection(urn)
+		if !found {
+			continue
+		}
+		selection, err := collection.Select(urn)
+		if err != nil {
+			continue
+		}
+		collectionURN, _ := ParseCite2URN(collection.Definition.URN)
+		if objects[collectionURN.CollectionURN()] == nil {
+			objects[collectionURN.CollectionURN()] = map[string]bool{}
+		}
+		for _, object := range selection {
+			objects[collectionURN.CollectionURN()][object.URN] = true
+		}
+		resolved = true
+	}
+	return resolved
+}
+
+//Adds the #!citecollections, #!citeproperties, #!citedata, #!imagedata and #!datamodels lines of the collections in objects to
+//the subset. Only the #!citedata records of the objects are added.
+func (library *Library) subsetCollections(subset *CEXData, objects map[string]map[string]bool) {
+	if len(objects) == 0 {
+		return
+	}
+	exported := func(value string) bool {
+		urn, err := ParseCite2URN(value)
+		return err == nil && objects[urn.CollectionURN()] != nil
+	}
+	for _, collection := range library.CEX.Collections {
+		if exported(collection.URN) {
+			subset.Collections = append(subset.Collections, collection)
+		}
+	}
+	for _, property := range library.CEX.Properties {
+		if exported(property.URN) {
+			subset.Properties = append(subset.Properties, property)
+		}
+	}
+	for _, dataBlock := range library.CEX.CiteData {
+		column := -1
+		for i, name := range dataBlock.Header {
+			if strings.EqualFold(name, "urn") {
+				column = i
+			}
+		}
+		if column < 0 {
+			continue
+		}
+		block := CiteDataBlock{Header: dataBlock.Header}
+		for _, record := range dataBlock.Records {
+			if urn, err := ParseCite2URN(record[column]); err == nil && objects[urn.CollectionURN()][record[column]] {
+				block.Records = append(block.Records, record)
+			}
+		}
+		if len(block.Records) > 0 {
+			subset.CiteData = append(subset.CiteData, block)
+		}
+	}
+	for _, image := range library.CEX.ImageData {
+		if exported(image.Collection) {
+			subset.ImageData = append(subset.ImageData, image)
+		}
+	}
+	for _, model := range library.CEX.DataModels {
+		if exported(model.Collection) {
+			subset.DataModels = append(subset.DataModels, model)
+		}
+	}
+}
+
+//Endpoint Handling Block: contains the handle functions that are executed according to the request.
+
+//ReturnWorkURNS returns the URNs as found in the #!ctsdata block of the CEX file
+func ReturnWorkURNS(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturWorkURNS")
+	vars := mux.Vars(r)
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/texts", loadError)
+		return
+	}
+	var result URNResponse
+	for _, workURN := range library.WorkURNs {
+		result.URN = append(result.URN, workURN+":")
+	}
+	result.Status = "Success"
+	result.Service = "/texts"
+	result.LibraryInfo = library.Info()
+	result.requestURN = []string{}
+	resultJSON, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintln(w, string(resultJSON))
+	clog.Info("ReturWorkURNS executed succesfully")
+}
+
+//Reloads the requested library, or every loaded library and the configuration file if no CEX is given.
+func ReturnReload(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnReload")
+	vars := mux.Vars(r)
+	requestCEX, specified := vars["CEX"]
+	result := ReloadResponse{Status: "Success", Service: "/reload", Libraries: []string{}}
+	switch {
+	case specified:
+		if _, err := registry.Reload(requestCEX); err != nil {
+			result.Status = "Exception"
+			result.Message = "Could not reload " + requestCEX + ": " + err.Error()
+			break
+		}
+		result.Libraries = append(result.Libraries, requestCEX)
+	default:
+		reloaded, err := registry.ReloadAll()
+		if err != nil {
+			result.Status = "Exception"
+			result.Message = "Reload stopped: " + err.Error()
+		}
+		result.Libraries = append(result.Libraries, reloaded...)
+	}
+	writeJSON(w, result)
+	clog.Info("ReturnReload executed with status " + result.Status)
+}
+
+func ReturnCiteVersion(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnCiteVersion")
+	var result CITEResponse
+	result = CITEResponse{Status: "Success",
+		Service:  "/cite",
+		Versions: Versions{Texts: "1.1.0", Textcatalog: "", Citedata: "1.0.0", Citecatalog: "1.0.0", Citerelations: "1.0.0", DSE: "1.0.0", ORCA: "1.0.0"}}
+	resultJSON, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	clog.Info("ReturnCiteVersion executed succesfully")
+	fmt.Fprintln(w, string(resultJSON))
+}
+
+func ReturnTextsVersion(w http.ResponseWriter, r *http.Request) {
 	clog.Info("Called function: ReturnTextsVersion")
 	var result VersionResponse
 	result = VersionResponse{
@@ -502,75 +3450,38 @@ func ReturnTextsVersion(w http.ResponseWriter, r *http.Request) {
 
 func ReturnFirst(w http.ResponseWriter, r *http.Request) {
 	clog.Info("Called function: ReturnFirst")
-	confvar := LoadConfiguration("config.json")
 	vars := mux.Vars(r)
-	requestCEX := ""
-	requestCEX = vars["CEX"]
-	var sourcetext string
-	switch {
-	case requestCEX != "":
-		sourcetext = confvar.Source + requestCEX + ".cex"
-		clog.Info("CEX-file provided in URL: " + requestCEX + ". Using " + sourcetext + ".")
-	default:
-		sourcetext = confvar.TestSource
-		clog.Info("No CEX-file provided in URL. Using " + confvar.TestSource + " from config instead.")
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/texts/first", loadError)
+		return
 	}
 	requestURN := vars["URN"]
 	//log.Println("Requested URN: " + requestURN)
-	if isCTSURN(requestURN) != true {
-		message := requestURN + " is not valid CTS."
+	requestCTS, urnError := library.ParseCTSURN(requestURN)
+	if urnError != nil {
+		message := requestURN + " is not valid CTS: " + urnError.Error()
 		result := NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
 		result.Service = "/texts/first"
+		result.LibraryInfo = library.Info()
 		resultJSON, _ := json.Marshal(result)
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		fmt.Fprintln(w, string(resultJSON))
 		clog.Info("ReturnLast executed succesfully")
 		return
 	}
-	workResult := ParseWork(CTSParams{Sourcetext: sourcetext})
-	works := append([]string(nil), workResult.URN...)
-	for i := range workResult.URN {
-		works[i] = strings.Join(strings.Split(workResult.URN[i], ":")[0:4], ":")
-	}
-	works = removeDuplicatesUnordered(works)
-	workindex := 0
-	for i := range works {
-		if strings.Contains(requestURN, works[i]) {
-			teststring := works[i] + ":"
-			switch {
-			case requestURN == works[i]:
-				workindex = i + 1
-			case strings.Contains(requestURN, teststring):
-				workindex = i + 1
-			}
-		}
-	}
+	RequestedWork, found := library.FindWork(requestCTS)
 	var result NodeResponse
 	switch {
-	case workindex == 0:
+	case !found:
 		message := "No results for " + requestURN
 		clog.Error("Requested URN not in works. Returning exception message")
 		result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
 	default:
-		var RequestedWork Work
-		RequestedWork.WorkURN = works[workindex-1]
-		runindex := 0
-		for i := range workResult.URN {
-			if strings.Join(strings.Split(workResult.URN[i], ":")[0:4], ":") == RequestedWork.WorkURN {
-				RequestedWork.URN = append(RequestedWork.URN, workResult.URN[i])
-				RequestedWork.Text = append(RequestedWork.Text, workResult.Text[i])
-				runindex++
-				RequestedWork.Index = append(RequestedWork.Index, runindex)
-			}
-		}
-		result = NodeResponse{requestURN: []string{requestURN},
-			Status: "Success",
-			Nodes: []Node{Node{URN: []string{RequestedWork.URN[0]},
-				Text:  []string{RequestedWork.Text[0]},
-				Next:  []string{RequestedWork.URN[1]},
-				Index: RequestedWork.Index[0]}}}
+		result = NodeResponse{requestURN: []string{requestURN}, Status: "Success", Nodes: RequestedWork.Nodes(0, 0, nil)}
 	}
 	result.Service = "/texts/first"
+	result.LibraryInfo = library.Info()
 	resultJSON, _ := json.Marshal(result)
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	clog.Info("ReturnFirst executed succesfully")
@@ -579,73 +3490,37 @@ func ReturnFirst(w http.ResponseWriter, r *http.Request) {
 
 func ReturnLast(w http.ResponseWriter, r *http.Request) {
 	clog.Info("Called function: ReturnLast")
-	confvar := LoadConfiguration("config.json")
 	vars := mux.Vars(r)
-	requestCEX := ""
-	requestCEX = vars["CEX"]
-	var sourcetext string
-	switch {
-	case requestCEX != "":
-		sourcetext = confvar.Source + requestCEX + ".cex"
-		clog.Info("CEX-file provided in URL: " + requestCEX + ". Using " + sourcetext + ".")
-	default:
-		sourcetext = confvar.TestSource
-		clog.Info("No CEX-file provided in URL. Using " + confvar.TestSource + " from config instead.")
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/texts/last", loadError)
+		return
 	}
 	requestURN := vars["URN"]
-	if isCTSURN(requestURN) != true {
-		message := requestURN + " is not valid CTS."
+	requestCTS, urnError := library.ParseCTSURN(requestURN)
+	if urnError != nil {
+		message := requestURN + " is not valid CTS: " + urnError.Error()
 		result := NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
 		result.Service = "/texts/last"
+		result.LibraryInfo = library.Info()
 		resultJSON, _ := json.Marshal(result)
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		fmt.Fprintln(w, string(resultJSON))
 		clog.Info("ReturnLast executed succesfully")
 		return
 	}
-	workResult := ParseWork(CTSParams{Sourcetext: sourcetext})
-	works := append([]string(nil), workResult.URN...)
-	for i := range workResult.URN {
-		works[i] = strings.Join(strings.Split(workResult.URN[i], ":")[0:4], ":")
-	}
-	works = removeDuplicatesUnordered(works)
-	workindex := 0
-	for i := range works {
-		if strings.Contains(requestURN, works[i]) {
-			teststring := works[i] + ":"
-			switch {
-			case requestURN == works[i]:
-				workindex = i + 1
-			case strings.Contains(requestURN, teststring):
-				workindex = i + 1
-			}
-		}
-	}
+	RequestedWork, found := library.FindWork(requestCTS)
 	var result NodeResponse
 	switch {
-	case workindex == 0:
+	case !found:
 		message := "No results for " + requestURN
 		result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
 	default:
-		var RequestedWork Work
-		RequestedWork.WorkURN = works[workindex-1]
-		runindex := 0
-		for i := range workResult.URN {
-			if strings.Join(strings.Split(workResult.URN[i], ":")[0:4], ":") == RequestedWork.WorkURN {
-				RequestedWork.URN = append(RequestedWork.URN, workResult.URN[i])
-				RequestedWork.Text = append(RequestedWork.Text, workResult.Text[i])
-				runindex++
-				RequestedWork.Index = append(RequestedWork.Index, runindex)
-			}
-		}
-		result = NodeResponse{requestURN: []string{requestURN},
-			Status: "Success",
-			Nodes: []Node{Node{URN: []string{RequestedWork.URN[len(RequestedWork.URN)-1]},
-				Text:     []string{RequestedWork.Text[len(RequestedWork.URN)-1]},
-				Previous: []string{RequestedWork.URN[len(RequestedWork.URN)-2]},
-				Index:    RequestedWork.Index[len(RequestedWork.URN)-1]}}}
+		lastIndex := len(RequestedWork.URN) - 1
+		result = NodeResponse{requestURN: []string{requestURN}, Status: "Success", Nodes: RequestedWork.Nodes(lastIndex, lastIndex, nil)}
 	}
 	result.Service = "/texts/last"
+	result.LibraryInfo = library.Info()
 	resultJSON, _ := json.Marshal(result)
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	fmt.Fprintln(w, string(resultJSON))
@@ -653,73 +3528,35 @@ func ReturnLast(w http.ResponseWriter, r *http.Request) {
 
 func ReturnPrev(w http.ResponseWriter, r *http.Request) {
 	clog.Info("Called function: ReturnPrev")
-	confvar := LoadConfiguration("config.json")
 	vars := mux.Vars(r)
-	requestCEX := ""
-	requestCEX = vars["CEX"]
-	var sourcetext string
-	switch {
-	case requestCEX != "":
-		sourcetext = confvar.Source + requestCEX + ".cex"
-		clog.Info("CEX-file provided in URL: " + requestCEX + ". Using " + sourcetext + ".")
-	default:
-		sourcetext = confvar.TestSource
-		clog.Info("No CEX-file provided in URL. Using " + confvar.TestSource + " from config instead.")
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/texts/previous", loadError)
+		return
 	}
 	requestURN := vars["URN"]
-	if isCTSURN(requestURN) != true {
-		message := requestURN + " is not valid CTS."
+	requestCTS, urnError := library.ParseCTSURN(requestURN)
+	if urnError != nil {
+		message := requestURN + " is not valid CTS: " + urnError.Error()
 		result := NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
 		result.Service = "/texts/previous"
+		result.LibraryInfo = library.Info()
 		resultJSON, _ := json.Marshal(result)
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		fmt.Fprintln(w, string(resultJSON))
 		clog.Info("ReturnReff executed succesfully")
 		return
 	}
-	workResult := ParseWork(CTSParams{Sourcetext: sourcetext})
-	works := append([]string(nil), workResult.URN...)
-	for i := range workResult.URN {
-		works[i] = strings.Join(strings.Split(workResult.URN[i], ":")[0:4], ":")
-	}
-	works = removeDuplicatesUnordered(works)
-	workindex := 0
-	for i := range works {
-		if strings.Contains(requestURN, works[i]) {
-			teststring := works[i] + ":"
-			switch {
-			case requestURN == works[i]:
-				workindex = i + 1
-			case strings.Contains(requestURN, teststring):
-				workindex = i + 1
-			}
-		}
-	}
+	RequestedWork, found := library.FindWork(requestCTS)
 	var result NodeResponse
 	switch {
-	case workindex == 0:
+	case !found:
 		message := "No results for " + requestURN
 		result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
 	default:
-		var RequestedWork Work
-		RequestedWork.WorkURN = works[workindex-1]
-		runindex := 0
-		for i := range workResult.URN {
-			if strings.Join(strings.Split(workResult.URN[i], ":")[0:4], ":") == RequestedWork.WorkURN {
-				RequestedWork.URN = append(RequestedWork.URN, workResult.URN[i])
-				RequestedWork.Text = append(RequestedWork.Text, workResult.Text[i])
-				runindex++
-				RequestedWork.Index = append(RequestedWork.Index, runindex)
-			}
-		}
-		var requestedIndex int
-		for i := range RequestedWork.URN {
-			if RequestedWork.URN[i] == requestURN {
-				requestedIndex = i
-			}
-		}
+		requestedIndex, requested := RequestedWork.NodeIndex[requestURN]
 		switch {
-		case contains(RequestedWork.URN, requestURN):
+		case requested:
 			switch {
 			case requestedIndex == 0:
 				result = NodeResponse{requestURN: []string{requestURN}, Status: "Success", Nodes: []Node{}}
@@ -740,11 +3577,17 @@ func ReturnPrev(w http.ResponseWriter, r *http.Request) {
 						Index:    RequestedWork.Index[requestedIndex-1]}}}
 			}
 		default:
+			container, found := RequestedWork.FindCitation(requestCTS.Begin)
+			if found && requestCTS.HasPassage() && !requestCTS.Range && container.Position < 0 {
+				result = library.siblingContainer(RequestedWork, container, -1, requestURN)
+				break
+			}
 			message := "Could not find node to " + requestURN + " in source."
 			result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
 		}
 	}
 	result.Service = "/texts/previous"
+	result.LibraryInfo = library.Info()
 	resultJSON, _ := json.Marshal(result)
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	fmt.Fprintln(w, string(resultJSON))
@@ -753,73 +3596,35 @@ func ReturnPrev(w http.ResponseWriter, r *http.Request) {
 
 func ReturnNext(w http.ResponseWriter, r *http.Request) {
 	clog.Info("Called function: ReturnNext")
-	confvar := LoadConfiguration("config.json")
 	vars := mux.Vars(r)
-	requestCEX := ""
-	requestCEX = vars["CEX"]
-	var sourcetext string
-	switch {
-	case requestCEX != "":
-		sourcetext = confvar.Source + requestCEX + ".cex"
-		clog.Info("CEX-file provided in URL: " + requestCEX + ". Using " + sourcetext + ".")
-	default:
-		sourcetext = confvar.TestSource
-		clog.Info("No CEX-file provided in URL. Using " + confvar.TestSource + " from config instead.")
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/texts/next", loadError)
+		return
 	}
 	requestURN := vars["URN"]
-	if isCTSURN(requestURN) != true {
-		message := requestURN + " is not valid CTS."
+	requestCTS, urnError := library.ParseCTSURN(requestURN)
+	if urnError != nil {
+		message := requestURN + " is not valid CTS: " + urnError.Error()
 		result := NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
 		result.Service = "/texts/next"
+		result.LibraryInfo = library.Info()
 		resultJSON, _ := json.Marshal(result)
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		fmt.Fprintln(w, string(resultJSON))
 		clog.Info("ReturnReff executed succesfully")
 		return
 	}
-	workResult := ParseWork(CTSParams{Sourcetext: sourcetext})
-	works := append([]string(nil), workResult.URN...)
-	for i := range workResult.URN {
-		works[i] = strings.Join(strings.Split(workResult.URN[i], ":")[0:4], ":")
-	}
-	works = removeDuplicatesUnordered(works)
-	workindex := 0
-	for i := range works {
-		if strings.Contains(requestURN, works[i]) {
-			teststring := works[i] + ":"
-			switch {
-			case requestURN == works[i]:
-				workindex = i + 1
-			case strings.Contains(requestURN, teststring):
-				workindex = i + 1
-			}
-		}
-	}
+	RequestedWork, found := library.FindWork(requestCTS)
 	var result NodeResponse
 	switch {
-	case workindex == 0:
+	case !found:
 		message := "No results for " + requestURN
 		result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
 	default:
-		var RequestedWork Work
-		RequestedWork.WorkURN = works[workindex-1]
-		runindex := 0
-		for i := range workResult.URN {
-			if strings.Join(strings.Split(workResult.URN[i], ":")[0:4], ":") == RequestedWork.WorkURN {
-				RequestedWork.URN = append(RequestedWork.URN, workResult.URN[i])
-				RequestedWork.Text = append(RequestedWork.Text, workResult.Text[i])
-				runindex++
-				RequestedWork.Index = append(RequestedWork.Index, runindex)
-			}
-		}
-		var requestedIndex int
-		for i := range RequestedWork.URN {
-			if RequestedWork.URN[i] == requestURN {
-				requestedIndex = i
-			}
-		}
+		requestedIndex, requested := RequestedWork.NodeIndex[requestURN]
 		switch {
-		case contains(RequestedWork.URN, requestURN):
+		case requested:
 			switch {
 			case requestedIndex == len(RequestedWork.URN)-1:
 				result = NodeResponse{requestURN: []string{requestURN}, Status: "Success", Nodes: []Node{}}
@@ -840,11 +3645,17 @@ func ReturnNext(w http.ResponseWriter, r *http.Request) {
 						Index:    RequestedWork.Index[requestedIndex+1]}}}
 			}
 		default:
+			container, found := RequestedWork.FindCitation(requestCTS.Begin)
+			if found && requestCTS.HasPassage() && !requestCTS.Range && container.Position < 0 {
+				result = library.siblingContainer(RequestedWork, container, 1, requestURN)
+				break
+			}
 			message := "Could not find node to " + requestURN + " in source."
 			result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
 		}
 	}
 	result.Service = "/texts/next"
+	result.LibraryInfo = library.Info()
 	resultJSON, _ := json.Marshal(result)
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	clog.Info("ReturnNext executed succesfully")
@@ -852,589 +3663,123 @@ func ReturnNext(w http.ResponseWriter, r *http.Request) {
 }
 
 func ReturnReff(w http.ResponseWriter, r *http.Request) {
-	clog.Info("Called function: ReturnReff")
-	confvar := LoadConfiguration("config.json") //load configuration from json file (ServerConfig)
-	vars := mux.Vars(r)                         //load vars from mux config to get CEX and URN information )[]string ?)
-	requestCEX := ""                            //initalize CEX variable (string)
-	requestCEX = vars["CEX"]                    //save CEX name in CEX variable
-	var sourcetext string                       //initialize sourcetext variable; will hold CEX data
-	switch {                                    //switch to determine wether a CEX file was specified
-	case requestCEX != "":
-		sourcetext = confvar.Source + requestCEX + ".cex" //build URL to CEX file if CEX file was specified
-		clog.Info("CEX-file provided in URL: " + requestCEX + ". Using " + sourcetext + ".")
-	default:
-		sourcetext = confvar.TestSource //use TestSource as URL to CEX-file as found in config.json
-		clog.Info("No CEX-file provided in URL. Using " + confvar.TestSource + " from config instead.")
-	}
-	requestURN := vars["URN"]         //safe requested URN
-	if isCTSURN(requestURN) != true { //test if given URN is valid (bool)
-		message := requestURN + " is not valid CTS."                                                    //build message part of NodeResponse
-		result := NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message} //building result (NodeResponse)
-		result.Service = "/texts/urns"                                                                  // adding Service part to result (NodeResponse)
-		resultJSON, _ := json.Marshal(result)                                                           //parsing result to JSON format (_ would contain err)
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")                               //set output format
-		fmt.Fprintln(w, string(resultJSON))                                                             //output
-		clog.Info("ReturnReff executed succesfully")
-		return
-	}
-	workResult := ParseWork(CTSParams{Sourcetext: sourcetext}) //parse the work
-	works := append([]string(nil), workResult.URN...)          // append URNs from workResult to works
-	for i := range workResult.URN {
-		works[i] = strings.Join(strings.Split(workResult.URN[i], ":")[0:4], ":") //crop URNs in []work to first four parts of URN
-	}
-	works = removeDuplicatesUnordered(works) //remove dublicate URNS
-	workindex := 0                           //initialize variable to save index of works to work with
-	for i := range works {
-		if strings.Contains(requestURN, works[i]) {
-			teststring := works[i] + ":" //add colon which was lost during joins
-			switch {
-			case requestURN == works[i]:
-				workindex = i + 1
-			case strings.Contains(requestURN, teststring): //should have matched before already, shouldn't it?
-				workindex = i + 1
-			}
-		}
-	}
-	var result URNResponse //initialize result (URNResponse)
-	switch {
-	case workindex == 0: //if requested URN is not among URNs in works prepare and display message accordingly
-		message := "No results for " + requestURN
-		result = URNResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
-		result.Service = "/texts/urns"
-		resultJSON, _ := json.Marshal(result)
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		fmt.Fprintln(w, string(resultJSON))
-	default: // if requested URN is among URNs in work
-		var RequestedWork Work
-		RequestedWork.WorkURN = works[workindex-1]
-		runindex := 0
-		for i := range workResult.URN {
-			if strings.Join(strings.Split(workResult.URN[i], ":")[0:4], ":") == RequestedWork.WorkURN {
-				RequestedWork.URN = append(RequestedWork.URN, workResult.URN[i])
-				RequestedWork.Text = append(RequestedWork.Text, workResult.Text[i])
-				runindex++
-				RequestedWork.Index = append(RequestedWork.Index, runindex)
-			}
-		}
-		switch {
-		case isRange(requestURN): //if range is requested,
-			ctsurn := splitCTS(requestURN)                   //split URN into its stem and reference
-			ctsrange := strings.Split(ctsurn.Reference, "-") //split reference along the hyphen
-			startURN := ctsurn.Stem + ":" + ctsrange[0]      //define startURN as the first field
-			endURN := ctsurn.Stem + ":" + ctsrange[1]        //definde endURN as the second field
-			var startindex, endindex int
-			switch { //find startindex in RequestedWork.URN
-			case contains(RequestedWork.URN, startURN): //if the startURN is in the URNs of RequestedWork use its index as startindex
-				for i := range RequestedWork.URN {
-					if RequestedWork.URN[i] == startURN {
-						startindex = i
-					}
-				}
-			case level1contains(RequestedWork.URN, startURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((startURN + "([:|.]*[0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := range match {
-					if match[i] == true {
-						startindex = i
-						break
-					}
-				}
-			case level2contains(RequestedWork.URN, startURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((startURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := range match {
-					if match[i] == true {
-						startindex = i
-						break
-					}
-				}
-			case level3contains(RequestedWork.URN, startURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((startURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := range match {
-					if match[i] == true {
-						startindex = i
-						break
-					}
-				}
-			case level4contains(RequestedWork.URN, startURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((startURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := range match {
-					if match[i] == true {
-						startindex = i
-						break
-					}
-				}
-			default:
-				startindex = 0
-			}
-			switch { //find endindex in RequestedWork.URN
-			case contains(RequestedWork.URN, endURN):
-				for i := range RequestedWork.URN {
-					if RequestedWork.URN[i] == endURN {
-						endindex = i
-					}
-				}
-			case level1contains(RequestedWork.URN, endURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((endURN + "([:|.]*[0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := len(match) - 1; i >= 0; i-- {
-					if match[i] == true {
-						endindex = i
-						break
-					}
-				}
-			case level2contains(RequestedWork.URN, endURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((endURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := len(match) - 1; i >= 0; i-- {
-					if match[i] == true {
-						endindex = i
-						break
-					}
-				}
-			case level3contains(RequestedWork.URN, endURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((endURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := len(match) - 1; i >= 0; i-- {
-					if match[i] == true {
-						endindex = i
-						break
-					}
-				}
-			case level4contains(RequestedWork.URN, endURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((endURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := len(match) - 1; i >= 0; i-- {
-					if match[i] == true {
-						endindex = i
-						break
-					}
-				}
-			default:
-				endindex = len(RequestedWork.URN) - 1
-			}
-			range_urn := RequestedWork.URN[startindex : endindex+1]                                   //safe requested URNS in variable range_urn
-			result = URNResponse{requestURN: []string{requestURN}, Status: "Success", URN: range_urn} //assemble result
-			result.Service = "/texts/urns"
-			resultJSON, _ := json.Marshal(result)                             //parse result to json format
-			w.Header().Set("Content-Type", "application/json; charset=utf-8") //set output format
-			fmt.Fprintln(w, string(resultJSON))                               //output
-		default:
-			switch {
-			case contains(RequestedWork.URN, requestURN):
-				result = URNResponse{requestURN: []string{requestURN}, Status: "Success", URN: []string{requestURN}}
-			case level1contains(RequestedWork.URN, requestURN):
-				var matchingURNs []string
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((requestURN + "([:|.]*[0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := range match {
-					if match[i] == true {
-						matchingURNs = append(matchingURNs, RequestedWork.URN[i])
-					}
-				}
-				result = URNResponse{requestURN: []string{requestURN}, Status: "Success", URN: matchingURNs}
-			case level2contains(RequestedWork.URN, requestURN):
-				var matchingURNs []string
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((requestURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := range match {
-					if match[i] == true {
-						matchingURNs = append(matchingURNs, RequestedWork.URN[i])
-					}
-				}
-				result = URNResponse{requestURN: []string{requestURN}, Status: "Success", URN: matchingURNs}
-			case level3contains(RequestedWork.URN, requestURN):
-				var matchingURNs []string
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((requestURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := range match {
-					if match[i] == true {
-						matchingURNs = append(matchingURNs, RequestedWork.URN[i])
-					}
-				}
-				result = URNResponse{requestURN: []string{requestURN}, Status: "Success", URN: matchingURNs}
-			case level4contains(RequestedWork.URN, requestURN):
-				var matchingURNs []string
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((requestURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := range match {
-					if match[i] == true {
-						matchingURNs = append(matchingURNs, RequestedWork.URN[i])
-					}
-				}
-				result = URNResponse{requestURN: []string{requestURN}, Status: "Success", URN: matchingURNs}
-			default:
-				result = URNResponse{requestURN: []string{requestURN}, Status: "Exception", Message: "Couldn't find URN."}
-			}
+	clog.Info("Called function: ReturnReff")
+	vars := mux.Vars(r)
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/texts/urns", loadError)
+		return
+	}
+	requestURN := vars["URN"] //safe requested URN
+	requestCTS, urnError := library.ParseCTSURN(requestURN)
+	if urnError != nil { //test if given URN is valid (bool)
+		message := requestURN + " is not valid CTS: " + urnError.Error()                                //build message part of NodeResponse
+		result := NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message} //building result (NodeResponse)
+		result.Service = "/texts/urns"                                                                  // adding Service part to result (NodeResponse)
+		result.LibraryInfo = library.Info()
+		resultJSON, _ := json.Marshal(result)                             //parsing result to JSON format (_ would contain err)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8") //set output format
+		fmt.Fprintln(w, string(resultJSON))                               //output
+		clog.Info("ReturnReff executed succesfully")
+		return
+	}
+	level := 0 //all citable nodes
+	if query := r.URL.Query().Get("level"); query != "" {
+		var levelError error
+		level, levelError = strconv.Atoi(query)
+		if levelError != nil || level < 1 {
+			result := URNResponse{requestURN: []string{requestURN}, Status: "Exception", Message: "level must be a positive number, not " + query}
 			result.Service = "/texts/urns"
-			resultJSON, _ := json.Marshal(result)                             //parse result to json format
-			w.Header().Set("Content-Type", "application/json; charset=utf-8") //set output format
-			fmt.Fprintln(w, string(resultJSON))                               //output
+			result.LibraryInfo = library.Info()
+			writeJSON(w, result)
 			clog.Info("ReturnReff executed succesfully")
+			return
+		}
+	}
+	RequestedWork, found := library.FindWork(requestCTS)
+	var result URNResponse //initialize result (URNResponse)
+	switch {
+	case !found: //if requested URN is not among URNs in works prepare and display message accordingly
+		message := "No results for " + requestURN
+		result = URNResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
+		result.Service = "/texts/urns"
+		result.LibraryInfo = library.Info()
+		resultJSON, _ := json.Marshal(result)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprintln(w, string(resultJSON))
+	default: // if requested URN is among URNs in work
+		first, last, spanned := RequestedWork.Span(requestCTS) //find the citable node, container or range in the citation index
+		switch {
+		case spanned && level > 0:
+			result = URNResponse{requestURN: []string{requestURN}, Status: "Success", URN: RequestedWork.References(first, last, level)}
+		case spanned:
+			substrings, subrefError := RequestedWork.Substrings(requestCTS, first, last)
+			if subrefError != nil {
+				result = URNResponse{requestURN: []string{requestURN}, Status: "Exception", Message: "Couldn't resolve URN: " + subrefError.Error()}
+			} else {
+				result = URNResponse{requestURN: []string{requestURN}, Status: "Success", URN: RequestedWork.URN[first : last+1], Substrings: orderedSubstrings(substrings)}
+			}
+		default:
+			result = URNResponse{requestURN: []string{requestURN}, Status: "Exception", Message: "Couldn't find URN."}
 		}
+		result.Service = "/texts/urns"
+		result.LibraryInfo = library.Info()
+		resultJSON, _ := json.Marshal(result)                             //parse result to json format
+		w.Header().Set("Content-Type", "application/json; charset=utf-8") //set output format
+		fmt.Fprintln(w, string(resultJSON))                               //output
+		clog.Info("ReturnReff executed succesfully")
 	}
 }
 
 //Returns a passage according to CEX file and URN specified
 func ReturnPassage(w http.ResponseWriter, r *http.Request) {
 	clog.Info("Called function: ReturnPassage")
-	confvar := LoadConfiguration("config.json")
 	vars := mux.Vars(r)
-	requestCEX := ""
-	requestCEX = vars["CEX"]
-	var sourcetext string
-	switch {
-	case requestCEX != "":
-		sourcetext = confvar.Source + requestCEX + ".cex"
-		clog.Info("CEX-file provided in URL: " + requestCEX + ". Using " + sourcetext + ".")
-	default:
-		sourcetext = confvar.TestSource
-		clog.Info("No CEX-file provided in URL. Using " + confvar.TestSource + " from config instead.")
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/texts", loadError)
+		return
 	}
 	requestURN := vars["URN"]
-	if isCTSURN(requestURN) != true {
-		message := requestURN + " is not valid CTS."
+	requestCTS, urnError := library.ParseCTSURN(requestURN)
+	if urnError != nil {
+		message := requestURN + " is not valid CTS: " + urnError.Error()
 		result := NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
 		result.Service = "/texts"
+		result.LibraryInfo = library.Info()
 		resultJSON, _ := json.Marshal(result)
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		fmt.Fprintln(w, string(resultJSON))
 		clog.Info("ReturnPassage executed succesfully")
 		return
 	}
-	workResult := ParseWork(CTSParams{Sourcetext: sourcetext})
-	works := append([]string(nil), workResult.URN...)
-	for i := range workResult.URN {
-		works[i] = strings.Join(strings.Split(workResult.URN[i], ":")[0:4], ":")
-	}
-	works = removeDuplicatesUnordered(works)
-	workindex := 0
-	for i := range works {
-		if strings.Contains(requestURN, works[i]) {
-			teststring := works[i] + ":"
-			switch {
-			case requestURN == works[i]:
-				workindex = i + 1
-			case strings.Contains(requestURN, teststring):
-				workindex = i + 1
-			}
-		}
-	}
+	RequestedWork, found := library.FindWork(requestCTS)
 	var result NodeResponse
 	switch {
-	case workindex == 0:
+	case !found:
 		message := "No results for " + requestURN
 		result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
 	default:
-		var RequestedWork Work
-		RequestedWork.WorkURN = works[workindex-1]
-		runindex := 0
-		for i := range workResult.URN {
-			if strings.Join(strings.Split(workResult.URN[i], ":")[0:4], ":") == RequestedWork.WorkURN {
-				RequestedWork.URN = append(RequestedWork.URN, workResult.URN[i])
-				RequestedWork.Text = append(RequestedWork.Text, workResult.Text[i])
-				runindex++
-				RequestedWork.Index = append(RequestedWork.Index, runindex)
-			}
-		}
-		var requestedIndex int
-		for i := range RequestedWork.URN {
-			if RequestedWork.URN[i] == requestURN {
-				requestedIndex = i
-			}
-		}
+		requestedIndex, requested := RequestedWork.NodeIndex[requestURN]
+		first, last, spanned := RequestedWork.Span(requestCTS) //find the container or range in the citation index
 		switch {
-		case contains(RequestedWork.URN, requestURN):
-			switch {
-			case requestedIndex == 0:
-				result = NodeResponse{requestURN: []string{requestURN},
-					Status: "Success",
-					Nodes: []Node{Node{URN: []string{RequestedWork.URN[requestedIndex]},
-						Text:  []string{RequestedWork.Text[requestedIndex]},
-						Next:  []string{RequestedWork.URN[requestedIndex+1]},
-						Index: RequestedWork.Index[requestedIndex]}}}
-			case requestedIndex == len(RequestedWork.URN)-1:
-				result = NodeResponse{requestURN: []string{requestURN},
-					Status: "Success",
-					Nodes: []Node{Node{URN: []string{RequestedWork.URN[requestedIndex]},
-						Text:     []string{RequestedWork.Text[requestedIndex]},
-						Previous: []string{RequestedWork.URN[requestedIndex-1]},
-						Index:    RequestedWork.Index[requestedIndex]}}}
-			default:
-				result = NodeResponse{requestURN: []string{requestURN},
-					Status: "Success",
-					Nodes: []Node{Node{URN: []string{RequestedWork.URN[requestedIndex]},
-						Text:     []string{RequestedWork.Text[requestedIndex]},
-						Next:     []string{RequestedWork.URN[requestedIndex+1]},
-						Previous: []string{RequestedWork.URN[requestedIndex-1]},
-						Index:    RequestedWork.Index[requestedIndex]}}}
-			}
-		case level1contains(RequestedWork.URN, requestURN):
-			var matchingNodes []Node
-			var match []bool
-			for i := range RequestedWork.URN {
-				match2, _ := regexp.MatchString((requestURN + "([:|.]*[0-9|a-z]+)$"), RequestedWork.URN[i])
-				match = append(match, match2)
-			}
-			for i := range match {
-				if match[i] == true {
-					previousnode := ""
-					nextnode := ""
-					if RequestedWork.Index[i] > 1 {
-						previousnode = RequestedWork.URN[RequestedWork.Index[i]-2]
-					}
-					if RequestedWork.Index[i] < len(RequestedWork.URN) {
-						nextnode = RequestedWork.URN[RequestedWork.Index[i]]
-					}
-					matchingNodes = append(matchingNodes, Node{URN: []string{RequestedWork.URN[i]}, Text: []string{RequestedWork.Text[i]}, Previous: []string{previousnode}, Next: []string{nextnode}, Index: RequestedWork.Index[i]})
-				}
-			}
-			result = NodeResponse{requestURN: []string{requestURN}, Status: "Success", Nodes: matchingNodes}
-		case level2contains(RequestedWork.URN, requestURN):
-			var matchingNodes []Node
-			var match []bool
-			for i := range RequestedWork.URN {
-				match2, _ := regexp.MatchString((requestURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-				match = append(match, match2)
-			}
-			for i := range match {
-				if match[i] == true {
-					previousnode := ""
-					nextnode := ""
-					if RequestedWork.Index[i] > 1 {
-						previousnode = RequestedWork.URN[RequestedWork.Index[i]-2]
-					}
-					if RequestedWork.Index[i] < len(RequestedWork.URN) {
-						nextnode = RequestedWork.URN[RequestedWork.Index[i]]
-					}
-					matchingNodes = append(matchingNodes, Node{URN: []string{RequestedWork.URN[i]}, Text: []string{RequestedWork.Text[i]}, Previous: []string{previousnode}, Next: []string{nextnode}, Index: RequestedWork.Index[i]})
-				}
-			}
-			result = NodeResponse{requestURN: []string{requestURN}, Status: "Success", Nodes: matchingNodes}
-		case level3contains(RequestedWork.URN, requestURN):
-			var matchingNodes []Node
-			var match []bool
-			for i := range RequestedWork.URN {
-				match2, _ := regexp.MatchString((requestURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-				match = append(match, match2)
-			}
-			for i := range match {
-				if match[i] == true {
-					previousnode := ""
-					nextnode := ""
-					if RequestedWork.Index[i] > 1 {
-						previousnode = RequestedWork.URN[RequestedWork.Index[i]-2]
-					}
-					if RequestedWork.Index[i] < len(RequestedWork.URN) {
-						nextnode = RequestedWork.URN[RequestedWork.Index[i]]
-					}
-					matchingNodes = append(matchingNodes, Node{URN: []string{RequestedWork.URN[i]}, Text: []string{RequestedWork.Text[i]}, Previous: []string{previousnode}, Next: []string{nextnode}, Index: RequestedWork.Index[i]})
-				}
-			}
-			result = NodeResponse{requestURN: []string{requestURN}, Status: "Success", Nodes: matchingNodes}
-		case level4contains(RequestedWork.URN, requestURN):
-			var matchingNodes []Node
-			var match []bool
-			for i := range RequestedWork.URN {
-				match2, _ := regexp.MatchString((requestURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-				match = append(match, match2)
-			}
-			for i := range match {
-				if match[i] == true {
-					previousnode := ""
-					nextnode := ""
-					if RequestedWork.Index[i] > 1 {
-						previousnode = RequestedWork.URN[RequestedWork.Index[i]-2]
-					}
-					if RequestedWork.Index[i] < len(RequestedWork.URN) {
-						nextnode = RequestedWork.URN[RequestedWork.Index[i]]
-					}
-					matchingNodes = append(matchingNodes, Node{URN: []string{RequestedWork.URN[i]}, Text: []string{RequestedWork.Text[i]}, Previous: []string{previousnode}, Next: []string{nextnode}, Index: RequestedWork.Index[i]})
-				}
-			}
-			result = NodeResponse{requestURN: []string{requestURN}, Status: "Success", Nodes: matchingNodes}
-		case isRange(requestURN):
-			var rangeNodes []Node
-			ctsurn := splitCTS(requestURN)
-			ctsrange := strings.Split(ctsurn.Reference, "-")
-			startURN := ctsurn.Stem + ":" + ctsrange[0]
-			endURN := ctsurn.Stem + ":" + ctsrange[1]
-			var startindex, endindex int
-			switch {
-			case contains(RequestedWork.URN, startURN):
-				for i := range RequestedWork.URN {
-					if RequestedWork.URN[i] == startURN {
-						startindex = i
-					}
-				}
-			case level1contains(RequestedWork.URN, startURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((startURN + "([:|.]*[0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := range match {
-					if match[i] == true {
-						startindex = i
-						break
-					}
-				}
-			case level2contains(RequestedWork.URN, startURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((startURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := range match {
-					if match[i] == true {
-						startindex = i
-						break
-					}
-				}
-			case level3contains(RequestedWork.URN, startURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((startURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := range match {
-					if match[i] == true {
-						startindex = i
-						break
-					}
-				}
-			case level4contains(RequestedWork.URN, startURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((startURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := range match {
-					if match[i] == true {
-						startindex = i
-						break
-					}
-				}
-			default:
-				startindex = 0
-			}
-			switch {
-			case contains(RequestedWork.URN, endURN):
-				for i := range RequestedWork.URN {
-					if RequestedWork.URN[i] == endURN {
-						endindex = i
-					}
-				}
-			case level1contains(RequestedWork.URN, endURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((endURN + "([:|.]*[0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := len(match) - 1; i >= 0; i-- {
-					if match[i] == true {
-						endindex = i
-						break
-					}
-				}
-			case level2contains(RequestedWork.URN, endURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((endURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := len(match) - 1; i >= 0; i-- {
-					if match[i] == true {
-						endindex = i
-						break
-					}
-				}
-			case level3contains(RequestedWork.URN, endURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((endURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := len(match) - 1; i >= 0; i-- {
-					if match[i] == true {
-						endindex = i
-						break
-					}
-				}
-			case level4contains(RequestedWork.URN, endURN):
-				var match []bool
-				for i := range RequestedWork.URN {
-					match2, _ := regexp.MatchString((endURN + "([:|.]*[0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+).([0-9|a-z]+)$"), RequestedWork.URN[i])
-					match = append(match, match2)
-				}
-				for i := len(match) - 1; i >= 0; i-- {
-					if match[i] == true {
-						endindex = i
-						break
-					}
-				}
-			default:
-				endindex = len(RequestedWork.URN) - 1
-			}
-			range_urn := RequestedWork.URN[startindex : endindex+1]
-			range_text := RequestedWork.Text[startindex : endindex+1]
-			range_index := RequestedWork.Index[startindex : endindex+1]
-			for i := range range_urn {
-				previousnode := ""
-				nextnode := ""
-				if range_index[i] > 1 {
-					previousnode = RequestedWork.URN[range_index[i]-2]
-				}
-				if range_index[i] < len(RequestedWork.URN) {
-					nextnode = RequestedWork.URN[range_index[i]]
-				}
-				rangeNodes = append(rangeNodes, Node{URN: []string{range_urn[i]}, Text: []string{range_text[i]}, Previous: []string{previousnode}, Next: []string{nextnode}, Index: range_index[i]})
+		case requested:
+			result = NodeResponse{requestURN: []string{requestURN}, Status: "Success", Nodes: RequestedWork.Nodes(requestedIndex, requestedIndex, nil)}
+		case spanned:
+			substrings, subrefError := RequestedWork.Substrings(requestCTS, first, last)
+			if subrefError != nil {
+				message := "Could not resolve " + requestURN + ": " + subrefError.Error()
+				result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
+			} else {
+				result = NodeResponse{requestURN: []string{requestURN}, Status: "Success", Nodes: RequestedWork.Nodes(first, last, substrings)}
 			}
-			result = NodeResponse{requestURN: []string{requestURN}, Status: "Success", Nodes: rangeNodes}
 		default:
 			message := "Could not find node to " + requestURN + " in source."
 			result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message}
 		}
 	}
 	result.Service = "/texts"
+	result.LibraryInfo = library.Info()
 	resultJSON, _ := json.Marshal(result)
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	clog.Info("ReturnPassage executed succesfully")
@@ -1443,52 +3788,58 @@ func ReturnPassage(w http.ResponseWriter, r *http.Request) {
 
 func ReturnCatalog(w http.ResponseWriter, r *http.Request) {
 	clog.Info("Called function: ReturnCatalog")
-	confvar := LoadConfiguration("config.json") //load configuration from json file (ServerConfig)
-	vars := mux.Vars(r)                         //load vars from mux config to get CEX and URN information )[]string ?)
-	requestCEX := ""                            //initalize CEX variable (string)
-	requestCEX = vars["CEX"]                    //save CEX name in CEX variable
-	var sourcetext string                       //initialize sourcetext variable; will hold CEX data
-	switch {                                    //switch to determine wether a CEX file was specified
-	case requestCEX != "": //either {CEX}/catalog/ or /{CEX}/catalog/{URN}
-		sourcetext = confvar.Source + requestCEX + ".cex" //build URL to CEX file if CEX file was specified
-		clog.Info("CEX-file provided in URL: " + requestCEX + ". Using " + sourcetext + ".")
-	default:
-		sourcetext = confvar.TestSource //use TestSource as URL to CEX-file as found in config.json instead
-		clog.Info("No CEX-file provided in URL. Using " + confvar.TestSource + " from congfig instead.")
+	vars := mux.Vars(r)                                 //load vars from mux config to get CEX and URN information
+	library, loadError := registry.Library(vars["CEX"]) //either {CEX}/catalog/ or /{CEX}/catalog/{URN}
+	if loadError != nil {
+		writeLibraryException(w, "/catalog", loadError)
+		return
 	}
 
 	requestURN := ""         //initialize requestURN (string)
 	requestURN = vars["URN"] //safe URN in variable
+	query := r.URL.Query()
+	filter := CatalogFilter{Group: query.Get("group"), Work: query.Get("work"), Lang: query.Get("lang"), Online: query.Get("online"), Type: query.Get("type")}
+	if filter.Type != "" && filter.Type != "version" && filter.Type != "exemplar" {
+		result := CatalogResponse{Status: "Exception", Message: "type must be version or exemplar, not " + filter.Type}
+		result.Service = "/catalog"
+		result.LibraryInfo = library.Info()
+		writeJSON(w, result)
+		clog.Info("ReturnCatalog executed succesfully")
+		return
+	}
 
 	switch {
 	case requestURN != "": //if the request URN was specified (not empty)
-		requestURN = strings.Join(strings.Split(requestURN, ":")[0:4], ":") //crop URN to first 4 parts (passage not needed for catalog
-		requestURN = (requestURN + ":")                                     //add ":" in the end to match appearance in catalog.
-
-		if isCTSURN(requestURN) != true { //test if given URN is valid (bool), if not give an error message
-			message := requestURN + " is not valid CTS."                                                    //build message part of NodeResponse
+		requestCTS, urnError := library.ParseCTSURN(requestURN)
+		if urnError != nil { //test if given URN is valid (bool), if not give an error message
+			message := requestURN + " is not valid CTS: " + urnError.Error()                                //build message part of NodeResponse
 			result := NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: message} //building result (NodeResponse)
 			result.Service = "/catalog"                                                                     // adding Service part to result (NodeResponse)
-			resultJSON, _ := json.Marshal(result)                                                           //parsing result to JSON format (_ would contain err)
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")                               //set output format
-			fmt.Fprintln(w, string(resultJSON))                                                             //output
+			result.LibraryInfo = library.Info()
+			resultJSON, _ := json.Marshal(result)                             //parsing result to JSON format (_ would contain err)
+			w.Header().Set("Content-Type", "application/json; charset=utf-8") //set output format
+			fmt.Fprintln(w, string(resultJSON))                               //output
 			clog.Info("ReturnCatalog executed succesfully")
 			return
 		}
+		requestURN = requestCTS.DropPassage().String() //passage not needed for catalog; ends with ":" to match appearance in catalog
 
-		catalogResult := ParseCatalog(CTSParams{Sourcetext: sourcetext}) //parse the catalog
-		//ToDo: check if catalogResult is empty --> Message + log
-		entries := catalogResult.CatalogEntries // get Catalog Entries ([]CatalogEntry)
-		var urns []string                       // create array to hold urns
+		entries := library.Catalog.Filter(filter) // get Catalog Entries ([]CatalogEntry)
+		var urns []string                         // create array to hold urns
+		var found []CatalogEntry                  // entries of the requested URN and of the versions and exemplars below it
 		for i := range entries {
 			urns = append(urns, entries[i].URN)
+			if entryCTS, err := ParseCTSURN(entries[i].URN); err == nil && requestCTS.DropPassage().Contains(entryCTS) {
+				found = append(found, entries[i])
+			}
 		}
 		urns = removeDuplicatesUnordered(urns)
 		switch {
-		case contains(urns, requestURN):
+		case len(found) > 0:
 			message := requestURN + " is in the CTS Catalog."
-			result := CatalogResponse{Status: "Success", Message: message}
+			result := CatalogResponse{Status: "Success", Message: message, URN: []string{requestURN}, Entries: found}
 			result.Service = "/catalog"
+			result.LibraryInfo = library.Info()
 			resultJSON, _ := json.Marshal(result)
 			w.Header().Set("Content-Type", "application/json; charset=utf-8") //set output format
 			fmt.Fprintln(w, string(resultJSON))                               //output
@@ -1498,27 +3849,452 @@ func ReturnCatalog(w http.ResponseWriter, r *http.Request) {
 			message := requestURN + " is not in the CTS Catalog. Printing URNs in catalog" //build message part of CatalogResponse
 			result := CatalogResponse{Status: "Exception", Message: message, URN: urns}    //building result (CataloResponse)
 			result.Service = "/catalog"                                                    //adding Service part to result (NodeResponse)
-			resultJSON, _ := json.Marshal(result)                                          //parsing result to JSON format (_ would contain err)
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")              //set output format
-			fmt.Fprintln(w, string(resultJSON))                                            //output
+			result.LibraryInfo = library.Info()
+			resultJSON, _ := json.Marshal(result)                             //parsing result to JSON format (_ would contain err)
+			w.Header().Set("Content-Type", "application/json; charset=utf-8") //set output format
+			fmt.Fprintln(w, string(resultJSON))                               //output
 			clog.Info("ReturnCatalog executed succesfully")
 			return
 		}
 	default:
-		catalogResult := ParseCatalog(CTSParams{Sourcetext: sourcetext}) //parse the catalog
-		entries := catalogResult.CatalogEntries                          // get Catalog Entries ([]CatalogEntry)
-		var urns []string                                                // create string to hold urns
+		entries := library.Catalog.Filter(filter) // get Catalog Entries ([]CatalogEntry)
+		var urns []string                         // create string to hold urns
 		for i := range entries {
 			urns = append(urns, entries[i].URN)
 		}
 		urns = removeDuplicatesUnordered(urns)
 
-		message := "No URN specified. Printing URNs in catalog"                   //build message part of CatalogResponse
-		result := CatalogResponse{Status: "Success", Message: message, URN: urns} //building result (CataloResponse)
-		result.Service = "/catalog"                                               //adding Service part to result (NodeResponse)
-		resultJSON, _ := json.Marshal(result)                                     //parsing result to JSON format (_ would contain err)
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")         //set output format
-		fmt.Fprintln(w, string(resultJSON))                                       //output
+		message := "No URN specified. Printing URNs in catalog"                                     //build message part of CatalogResponse
+		result := CatalogResponse{Status: "Success", Message: message, URN: urns, Entries: entries} //building result (CataloResponse)
+		result.Service = "/catalog"                                                                 //adding Service part to result (NodeResponse)
+		result.LibraryInfo = library.Info()
+		resultJSON, _ := json.Marshal(result)                             //parsing result to JSON format (_ would contain err)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8") //set output format
+		fmt.Fprintln(w, string(resultJSON))                               //output
 		clog.Info("ReturnCatalog executed succesfully")
 	}
 }
+
+//Returns the containers or citable nodes immediately below a container, or the top level of a work given without passage
+func ReturnChildren(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnChildren")
+	writeCitations(w, r, "/texts/children", func(node *CitationNode) []*CitationNode {
+		return node.Children
+	})
+	clog.Info("ReturnChildren executed succesfully")
+}
+
+//Returns the container immediately enclosing a container or citable node; the root of the version if it is at the top level
+func ReturnParent(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnParent")
+	writeCitations(w, r, "/texts/parent", func(node *CitationNode) []*CitationNode {
+		if node.Parent == nil {
+			return nil
+		}
+		return []*CitationNode{node.Parent}
+	})
+	clog.Info("ReturnParent executed succesfully")
+}
+
+//Returns the path from the root of the version down to the container enclosing a container or citable node
+func ReturnAncestors(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnAncestors")
+	writeCitations(w, r, "/texts/ancestors", func(node *CitationNode) []*CitationNode {
+		return node.Ancestors()
+	})
+	clog.Info("ReturnAncestors executed succesfully")
+}
+
+//Writes the nodes related to the container or citable node of the requested URN in the citation hierarchy. Used by ReturnChildren,
+//ReturnParent and ReturnAncestors.
+func writeCitations(w http.ResponseWriter, r *http.Request, service string, related func(node *CitationNode) []*CitationNode) {
+	vars := mux.Vars(r)
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, service, loadError)
+		return
+	}
+	requestURN := vars["URN"]
+	result := CitationResponse{Status: "Success"}
+	requestCTS, urnError := library.ParseCTSURN(requestURN)
+	RequestedWork, found := library.FindWork(requestCTS)
+	var node *CitationNode
+	switch {
+	case urnError != nil:
+		result = CitationResponse{Status: "Exception", Message: requestURN + " is not valid CTS: " + urnError.Error()}
+	case requestCTS.Range:
+		result = CitationResponse{Status: "Exception", Message: "Ranges have no place in the citation hierarchy. Request " + requestCTS.RangeBegin().String() + " or " + requestCTS.RangeEnd().String()}
+	case !found:
+		result = CitationResponse{Status: "Exception", Message: "No results for " + requestURN}
+	default:
+		node, found = RequestedWork.FindCitation(requestCTS.Begin)
+		if !found {
+			result = CitationResponse{Status: "Exception", Message: "Could not find node to " + requestURN + " in source."}
+			break
+		}
+		for _, relative := range related(node) {
+			result.Citations = append(result.Citations, library.Citation(RequestedWork, relative))
+		}
+	}
+	if result.Citations == nil {
+		result.Citations = []Citation{}
+	}
+	result.Service = service
+	result.LibraryInfo = library.Info()
+	writeJSON(w, result)
+}
+
+//Returns a passage with ?n= (default 1) citable nodes before and after it, marking the nodes of the passage as requested.
+//With ?unit=container the context is counted in containers at the depth of the requested container, or of the container of a requested citable node
+func ReturnContext(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnContext")
+	vars := mux.Vars(r)
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/texts/context", loadError)
+		return
+	}
+	requestURN := vars["URN"]
+	query := r.URL.Query()
+	count, countError := 1, error(nil)
+	if query.Get("n") != "" {
+		count, countError = strconv.Atoi(query.Get("n"))
+	}
+	requestCTS, urnError := library.ParseCTSURN(requestURN)
+	RequestedWork, found := library.FindWork(requestCTS)
+	var result NodeResponse
+	switch {
+	case urnError != nil:
+		result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: requestURN + " is not valid CTS: " + urnError.Error()}
+	case countError != nil || count < 0:
+		result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: "n must be zero or a positive number, not " + query.Get("n")}
+	case query.Get("unit") != "" && query.Get("unit") != "node" && query.Get("unit") != "container":
+		result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: "unit must be node or container, not " + query.Get("unit")}
+	case !found:
+		result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: "No results for " + requestURN}
+	default:
+		first, last, spanned := RequestedWork.Span(requestCTS)
+		if !spanned {
+			result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: "Could not find node to " + requestURN + " in source."}
+			break
+		}
+		substrings, subrefError := RequestedWork.Substrings(requestCTS, first, last)
+		if subrefError != nil {
+			result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: "Could not resolve " + requestURN + ": " + subrefError.Error()}
+			break
+		}
+		depth := 0
+		if query.Get("unit") == "container" {
+			node, _ := RequestedWork.FindCitation(requestCTS.Begin)
+			depth = node.Depth
+			if node.Position >= 0 && len(node.Children) == 0 { //a citable node: count the containers it is in
+				depth--
+			}
+			if depth == 0 && node.Depth == 1 {
+				result = NodeResponse{requestURN: []string{requestURN}, Status: "Exception", Message: requestURN + " is not in a container; works with one citation level have none. Use unit=node"}
+				break
+			}
+		}
+		contextFirst, contextLast := RequestedWork.Context(first, last, count, depth)
+		nodes := RequestedWork.Nodes(contextFirst, contextLast, substrings)
+		for i := first; i <= last; i++ {
+			nodes[i-contextFirst].Requested = true
+		}
+		result = NodeResponse{requestURN: []string{requestURN}, Status: "Success", Nodes: nodes}
+	}
+	result.Service = "/texts/context"
+	result.LibraryInfo = library.Info()
+	writeJSON(w, result)
+	clog.Info("ReturnContext executed succesfully")
+}
+
+//Returns the nested table of contents of a version, exemplar or container. ?depth= limits it to containers down to this citation depth
+func ReturnTOC(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnTOC")
+	vars := mux.Vars(r)
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/texts/toc", loadError)
+		return
+	}
+	requestURN := vars["URN"]
+	depth, depthError := 0, error(nil)
+	if query := r.URL.Query().Get("depth"); query != "" {
+		depth, depthError = strconv.Atoi(query)
+	}
+	requestCTS, urnError := library.ParseCTSURN(requestURN)
+	RequestedWork, found := library.FindWork(requestCTS)
+	var result TOCResponse
+	switch {
+	case urnError != nil:
+		result = TOCResponse{Status: "Exception", Message: requestURN + " is not valid CTS: " + urnError.Error()}
+	case depthError != nil || depth < 0:
+		result = TOCResponse{Status: "Exception", Message: "depth must be zero or a positive number, not " + r.URL.Query().Get("depth")}
+	case requestCTS.Range:
+		result = TOCResponse{Status: "Exception", Message: "Ranges have no table of contents. Request the work or a container"}
+	case !found:
+		result = TOCResponse{Status: "Exception", Message: "No results for " + requestURN}
+	default:
+		node, found := RequestedWork.FindCitation(requestCTS.Begin)
+		if !found {
+			result = TOCResponse{Status: "Exception", Message: "Could not find node to " + requestURN + " in source."}
+			break
+		}
+		if depth == 0 { //all levels of containers
+			depth = len(RequestedWork.URN)
+		}
+		toc := library.TOC(RequestedWork, node, depth)
+		result = TOCResponse{Status: "Success", TOC: &toc}
+	}
+	result.Service = "/texts/toc"
+	result.LibraryInfo = library.Info()
+	writeJSON(w, result)
+	clog.Info("ReturnTOC executed succesfully")
+}
+
+//Returns the descriptions of all collections of the library, or of the collection given by URN
+func ReturnCollections(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnCollections")
+	vars := mux.Vars(r)
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/collections", loadError)
+		return
+	}
+	requestURN := vars["URN"]
+	var result CollectionResponse
+	switch {
+	case requestURN == "":
+		result = CollectionResponse{Status: "Success"}
+		for _, collectionURN := range library.CollectionURNs {
+			result.Collections = append(result.Collections, library.Collections[collectionURN].Describe())
+		}
+	default:
+		requestCite2, urnError := ParseCite2URN(requestURN)
+		if urnError != nil {
+			result = CollectionResponse{Status: "Exception", Message: requestURN + " is not valid CITE2: " + urnError.Error()}
+			break
+		}
+		collection, found := library.FindCollection(requestCite2)
+		if !found {
+			result = CollectionResponse{Status: "Exception", Message: "No collection " + requestCite2.CollectionURN() + " in library"}
+			break
+		}
+		result = CollectionResponse{Status: "Success", Collections: []CollectionDescription{collection.Describe()}}
+	}
+	result.Service = "/collections"
+	result.LibraryInfo = library.Info()
+	writeJSON(w, result)
+	clog.Info("ReturnCollections executed succesfully")
+}
+
+//Returns the objects identified by a CITE2 URN: a single object, a range of an ordered collection or a whole collection
+func ReturnObjects(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnObjects")
+	vars := mux.Vars(r)
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/objects", loadError)
+		return
+	}
+	requestURN := vars["URN"]
+	var result ObjectResponse
+	requestCite2, urnError := ParseCite2URN(requestURN)
+	collection, found := library.FindCollection(requestCite2)
+	switch {
+	case urnError != nil:
+		result = ObjectResponse{Status: "Exception", Message: requestURN + " is not valid CITE2: " + urnError.Error()}
+	case !found:
+		result = ObjectResponse{Status: "Exception", Message: "No collection " + requestCite2.CollectionURN() + " in library"}
+	default:
+		objects, selectError := collection.Select(requestCite2)
+		if selectError != nil {
+			result = ObjectResponse{Status: "Exception", Message: "Could not find " + requestURN + ": " + selectError.Error()}
+		} else {
+			result = ObjectResponse{Status: "Success", Objects: objects}
+		}
+	}
+	result.Service = "/objects"
+	result.LibraryInfo = library.Info()
+	writeJSON(w, result)
+	clog.Info("ReturnObjects executed succesfully")
+}
+
+//Returns the relations a CTS or CITE2 URN appears in, including relations of passages or objects it contains. ?verb= restricts them to one verb
+func ReturnRelations(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnRelations")
+	vars := mux.Vars(r)
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/relations", loadError)
+		return
+	}
+	requestURN := vars["URN"]
+	verb := r.URL.Query().Get("verb")
+	_, ctsError := library.ParseCTSURN(requestURN)
+	_, cite2Error := ParseCite2URN(requestURN)
+	var result RelationResponse
+	switch {
+	case ctsError != nil && cite2Error != nil:
+		result = RelationResponse{Status: "Exception", Message: requestURN + " is neither valid CTS nor valid CITE2"}
+	default:
+		result = RelationResponse{Status: "Success", Relations: library.RelationsOf(requestURN, verb)}
+		if len(result.Relations) == 0 {
+			result.Message = "No relations for " + requestURN
+		}
+	}
+	result.Service = "/relations"
+	result.LibraryInfo = library.Info()
+	writeJSON(w, result)
+	clog.Info("ReturnRelations executed succesfully")
+}
+
+//Returns the DSE records of a CTS passage URN, giving the surface and image region it is on, or of a surface or image URN, giving the passages on it
+func ReturnDSE(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnDSE")
+	vars := mux.Vars(r)
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/dse", loadError)
+		return
+	}
+	requestURN := vars["URN"]
+	_, ctsError := library.ParseCTSURN(requestURN)
+	_, cite2Error := ParseCite2URN(requestURN)
+	var result DSEResponse
+	switch {
+	case ctsError != nil && cite2Error != nil:
+		result = DSEResponse{Status: "Exception", Message: requestURN + " is neither valid CTS nor valid CITE2"}
+	default:
+		result = DSEResponse{Status: "Success", Records: library.DSEOf(requestURN)}
+		if len(result.Records) == 0 {
+			result.Message = "No DSE records for " + requestURN
+		}
+	}
+	result.Service = "/dse"
+	result.LibraryInfo = library.Info()
+	writeJSON(w, result)
+	clog.Info("ReturnDSE executed succesfully")
+}
+
+//Returns the ORCA analyses of a CTS passage URN, or the records of an analysis given by CITE2 URN
+func ReturnORCA(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnORCA")
+	vars := mux.Vars(r)
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/orca", loadError)
+		return
+	}
+	requestURN := vars["URN"]
+	_, ctsError := library.ParseCTSURN(requestURN)
+	_, cite2Error := ParseCite2URN(requestURN)
+	var result ORCAResponse
+	switch {
+	case ctsError != nil && cite2Error != nil:
+		result = ORCAResponse{Status: "Exception", Message: requestURN + " is neither valid CTS nor valid CITE2"}
+	default:
+		result = ORCAResponse{Status: "Success", Analyses: library.ORCAOf(requestURN)}
+		if len(result.Analyses) == 0 {
+			result.Message = "No analyses for " + requestURN
+		}
+	}
+	result.Service = "/orca"
+	result.LibraryInfo = library.Info()
+	writeJSON(w, result)
+	clog.Info("ReturnORCA executed succesfully")
+}
+
+//Returns the disagreements between #!ctscatalog and #!ctsdata found when the library was loaded
+func ReturnConsistency(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnConsistency")
+	vars := mux.Vars(r)
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/consistency", loadError)
+		return
+	}
+	result := ConsistencyResponse{Status: "Success", Service: "/consistency", Problems: library.Inconsistencies, LibraryInfo: library.Info()}
+	switch len(library.Inconsistencies) {
+	case 0:
+		result.Message = "Catalog and texts are consistent."
+	default:
+		result.Message = fmt.Sprintf("%d problems found.", len(library.Inconsistencies))
+	}
+	writeJSON(w, result)
+	clog.Info("ReturnConsistency executed succesfully")
+}
+
+//Returns the #!citelibrary block of the library: its name, URN, license and the namespaces of its URNs
+func ReturnLibrary(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnLibrary")
+	vars := mux.Vars(r)
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/library", loadError)
+		return
+	}
+	result := LibraryResponse{Status: "Success", Service: "/library", CiteLibrary: library.CEX.CiteLibrary, CEXVersion: library.CEX.VersionLabel(), LibraryInfo: library.Info()}
+	if result.CiteLibrary.Name == "" && result.CiteLibrary.URN == "" && result.CiteLibrary.License == "" {
+		result.Message = "The library has no #!citelibrary block."
+	}
+	writeJSON(w, result)
+	clog.Info("ReturnLibrary executed succesfully")
+}
+
+//Returns the library as canonical CEX, with the delimiters given by ?delimiter= and ?secondary_delimiter= or those of its source
+func ReturnCEX(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnCEX")
+	vars := mux.Vars(r)
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/cex", loadError)
+		return
+	}
+	delimiter := library.CEX.Delimiter
+	secondaryDelimiter := library.CEX.SecondaryDelimiter
+	query := r.URL.Query()
+	if query.Get("delimiter") != "" {
+		delimiter = query.Get("delimiter")
+	}
+	if query.Get("secondary_delimiter") != "" {
+		secondaryDelimiter = query.Get("secondary_delimiter")
+	}
+	text, writeError := WriteCEX(library.CEX, delimiter, secondaryDelimiter)
+	if writeError != nil {
+		writeJSON(w, LibraryExceptionResponse{Status: "Exception", Service: "/cex", Message: "Could not write CEX: " + writeError.Error(), LibraryInfo: library.Info()})
+		clog.Info("ReturnCEX executed succesfully")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, text)
+	clog.Info("ReturnCEX executed succesfully")
+}
+
+//Returns the works, passages and ranges given by ?urn= (repeatable) with their catalog rows and relations as a CEX file of their own
+func ReturnExport(w http.ResponseWriter, r *http.Request) {
+	clog.Info("Called function: ReturnExport")
+	vars := mux.Vars(r)
+	library, loadError := registry.Library(vars["CEX"])
+	if loadError != nil {
+		writeLibraryException(w, "/export", loadError)
+		return
+	}
+	query := r.URL.Query()
+	urns := query["urn"]
+	if len(urns) == 0 {
+		writeJSON(w, LibraryExceptionResponse{Status: "Exception", Service: "/export", Message: "No URN specified. Add one ?urn= for every work, passage or range to export", LibraryInfo: library.Info()})
+		clog.Info("ReturnExport executed succesfully")
+		return
+	}
+	text, exportError := exportSubset(library, urns, query.Get("delimiter"), query.Get("secondary_delimiter"))
+	if exportError != nil {
+		writeJSON(w, LibraryExceptionResponse{Status: "Exception", Service: "/export", Message: "Could not export: " + exportError.Error(), LibraryInfo: library.Info()})
+		clog.Info("ReturnExport executed succesfully")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"export.cex\"")
+	fmt.Fprint(w, text)
+	clog.Info("ReturnExport executed succesfully")
+}
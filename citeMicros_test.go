@@ -0,0 +1,1422 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+//CEX source with one work of two nodes.
+const registrySource = `#!ctscatalog
+urn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online#lang
+urn:cts:citeArch:groupA.work1.ed1:#book,line#Group A#Work 1#Edition 1##true#eng
+
+#!ctsdata
+urn:cts:citeArch:groupA.work1.ed1:1.1#Sing, goddess, the wrath of Achilles.
+urn:cts:citeArch:groupA.work1.ed1:1.2#That brought countless woes.
+`
+
+//Serves registrySource for every .cex file and counts the requests per path. Requests for slow.cex wait until release is closed.
+func registryServer(t *testing.T, release chan struct{}) (*httptest.Server, map[string]int, *sync.Mutex) {
+	requests := map[string]int{}
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests[r.URL.Path]++
+		mutex.Unlock()
+		if r.URL.Path == "/slow.cex" {
+			<-release
+		}
+		w.Write([]byte(registrySource))
+	}))
+	t.Cleanup(server.Close)
+	return server, requests, &mutex
+}
+
+//A library is fetched and parsed once, however often it is requested.
+func TestRegistryLoadsOnce(t *testing.T) {
+	server, requests, mutex := registryServer(t, nil)
+	registry := NewLibraryRegistry("")
+	registry.Config = ServerConfig{Source: server.URL + "/"}
+	for i := 0; i < 3; i++ {
+		library, err := registry.Library("fast")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(library.Works["urn:cts:citeArch:groupA.work1.ed1"].URN) != 2 {
+			t.Fatalf("got works %v, want one work of two nodes", library.WorkURNs)
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if requests["/fast.cex"] != 1 {
+		t.Errorf("fast.cex fetched %d times, want once", requests["/fast.cex"])
+	}
+}
+
+//A slow source does not hold up the first load of other libraries.
+func TestRegistryLocksPerLibrary(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	server, _, _ := registryServer(t, release)
+	registry := NewLibraryRegistry("")
+	registry.Config = ServerConfig{Source: server.URL + "/"}
+	go registry.Library("slow")
+	time.Sleep(50 * time.Millisecond) //let the slow load take its lock
+	loaded := make(chan error)
+	go func() {
+		_, err := registry.Library("fast")
+		loaded <- err
+	}()
+	select {
+	case err := <-loaded:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("loading fast waited for slow")
+	}
+}
+
+//Libraries of a local directory are found by name, whether the directory is given as path or as file:// URL.
+func TestSourceForDirectory(t *testing.T) {
+	directory := t.TempDir()
+	if err := os.WriteFile(filepath.Join(directory, "local.cex"), []byte(registrySource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		source, name, want string
+		fails              bool
+	}{
+		{directory, "local", filepath.Join(directory, "local.cex"), false},
+		{"file://" + filepath.ToSlash(directory), "local", filepath.Join(directory, "local.cex"), false},
+		{directory, "missing", "", true},
+		{"http://localhost:8000/", "remote", "http://localhost:8000/remote.cex", false},
+	} {
+		registry := NewLibraryRegistry("")
+		registry.Config = ServerConfig{Source: test.source, TestSource: "test.cex"}
+		got, err := registry.SourceFor(test.name)
+		if (err != nil) != test.fails || got != test.want {
+			t.Errorf("SourceFor(%q) in %v: got %q, %v", test.name, test.source, got, err)
+		}
+	}
+	registry := NewLibraryRegistry("")
+	registry.Config = ServerConfig{Source: "file://" + filepath.ToSlash(directory)}
+	if library, err := registry.Library("local"); err != nil || len(library.WorkURNs) != 1 {
+		t.Errorf("local library: got %v, %v", library, err)
+	}
+}
+
+//CEX source with every block type and the delimiters # and ,, a preamble and a comment line.
+const fullSource = `Preamble ignored
+#!cexversion
+//comment
+3.0
+
+#!citelibrary
+name#Full test
+urn#urn:cite2:test:cex.v1:full
+license#CC-BY
+namespace#citeArch#http://www.homermultitext.org/citens/citeArch
+
+#!ctscatalog
+urn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online#lang
+urn:cts:citeArch:groupA.work1.ed1:#book,line#Group A#Work 1#Edition 1##true#eng
+
+#!ctsdata
+urn:cts:citeArch:groupA.work1.ed1:1.1#Sing, goddess, the wrath of Achilles.
+urn:cts:citeArch:groupA.work1.ed1:1.2#That brought countless woes.
+
+#!citecollections
+URN#Description#Labelling property#Ordering property#License
+urn:cite2:hmt:msA.v1:#Pages of the Venetus A#urn:cite2:hmt:msA.v1.label:#urn:cite2:hmt:msA.v1.sequence:#CC-BY
+
+#!citeproperties
+Property#Label#Type#Authority list
+urn:cite2:hmt:msA.v1.urn:#Page URN#Cite2Urn#
+urn:cite2:hmt:msA.v1.label:#Label#String#
+urn:cite2:hmt:msA.v1.sequence:#Sequence#Number#
+urn:cite2:hmt:msA.v1.side:#Side#String#recto,verso
+
+#!citedata
+urn#label#sequence#side
+urn:cite2:hmt:msA.v1:1r#Folio 1 recto#1#recto
+urn:cite2:hmt:msA.v1:1v#Folio 1 verso#2.5#verso
+
+#!relations
+urn:cts:citeArch:groupA.work1.ed1:1.1-1.2#urn:cite2:dse:verbs.v1:appearsOn:#urn:cite2:hmt:msA.v1:1r
+
+#!datamodels
+Collection#Model#Label#Description
+urn:cite2:hmt:msA.v1:#urn:cite2:cite:datamodels.v1:pages#Pages#Pages model
+
+#!imagedata
+urn:cite2:hmt:vaimg.v1:#CITE image#http://www.homermultitext.org/hmtdigital/images?#urn:cite2:hmt:vaimg.v1.rights:
+`
+
+//Every block type is parsed into its own part of CEXData; header lines, comments and the preamble are skipped.
+func TestParseCEXBlocks(t *testing.T) {
+	cex := ParseCEX(CTSParams{Sourcetext: fullSource})
+	for _, test := range []struct {
+		part      string
+		got, want interface{}
+	}{
+		{"blocks", len(cex.Blocks), 10},
+		{"first block line", cex.Blocks[0].Line, 2},
+		{"version", cex.Version, "3.0"},
+		{"library name", cex.CiteLibrary.Name, "Full test"},
+		{"namespaces", len(cex.CiteLibrary.Namespaces), 1},
+		{"catalog entries", len(cex.Catalog.CatalogEntries), 1},
+		{"catalog lang", cex.Catalog.CatalogEntries[0].Lang, "eng"},
+		{"nodes", len(cex.CTSData.URN), 2},
+		{"second node", cex.CTSData.Text[1], "That brought countless woes."},
+		{"collections", len(cex.Collections), 1},
+		{"properties", len(cex.Properties), 4},
+		{"vocabulary", len(cex.Properties[3].Vocabulary), 2},
+		{"citedata records", len(cex.CiteData[0].Records), 2},
+		{"citedata header", len(cex.CiteData[0].Header), 4},
+		{"relations", len(cex.Relations), 1},
+		{"relation verb", cex.Relations[0].Verb, "urn:cite2:dse:verbs.v1:appearsOn:"},
+		{"datamodels", len(cex.DataModels), 1},
+		{"imagedata", len(cex.ImageData), 1},
+		{"image protocol", cex.ImageData[0].Protocol, "CITE image"},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s: got %v, want %v", test.part, test.got, test.want)
+		}
+	}
+}
+
+//The column delimiter is detected from the blocks with known column counts, the secondary delimiter from the citation schemes.
+func TestDetectDelimiters(t *testing.T) {
+	for _, test := range []struct {
+		delimiter, secondary string
+		header               bool
+	}{
+		{"#", ",", true},
+		{"|", ";", true},
+		{"\t", "/", true},
+		{"#", ";", false},
+	} {
+		catalog := []string{"urn:cts:citeArch:groupA.work1.ed1:", "book" + test.secondary + "line", "Group A", "Work 1", "Edition 1", "", "true", "eng"}
+		source := "#!ctscatalog\n"
+		if test.header {
+			source += strings.Join([]string{"urn", "citationScheme", "groupName", "workTitle", "versionLabel", "exemplarLabel", "online", "lang"}, test.delimiter) + "\n"
+		}
+		source += strings.Join(catalog, test.delimiter) + "\n\n#!ctsdata\nurn:cts:citeArch:groupA.work1.ed1:1.1" + test.delimiter + "One.\n"
+		blocks := SplitCEXBlocks(source)
+		if got := DetectDelimiter(blocks); got != test.delimiter {
+			t.Errorf("DetectDelimiter: got %q, want %q", got, test.delimiter)
+		}
+		if got := DetectSecondaryDelimiter(blocks, test.delimiter, undeclaredVersionRules); got != test.secondary {
+			t.Errorf("DetectSecondaryDelimiter with delimiter %q, header %v: got %q, want %q", test.delimiter, test.header, got, test.secondary)
+		}
+	}
+}
+
+//A first catalog line that does not name its columns is still skipped as a header if the rules require one.
+func TestDetectSecondaryDelimiterHeaderRule(t *testing.T) {
+	blocks := SplitCEXBlocks("#!ctscatalog\nurn#citation,scheme#groupName#workTitle#versionLabel#exemplarLabel#online#lang\nurn:cts:citeArch:groupA.work1.ed1:#book;line#Group A#Work 1#Edition 1##true#eng\n")
+	if got := DetectSecondaryDelimiter(blocks, "#", cexVersionRules[1]); got != ";" {
+		t.Errorf("header required by the rules: got %q, want ;", got)
+	}
+	if got := DetectSecondaryDelimiter(blocks, "#", undeclaredVersionRules); got != "," {
+		t.Errorf("header not required: got %q, want ,", got)
+	}
+}
+
+//Delimiters configured for a library override the top level settings; declared delimiters are not detected.
+func TestSettingsFor(t *testing.T) {
+	registry := NewLibraryRegistry("")
+	registry.Config.LibraryConfig = LibraryConfig{Delimiter: "|"}
+	registry.Config.Libraries = map[string]LibraryConfig{"tabs": {Delimiter: "\t", SecondaryDelimiter: ";"}}
+	if got := registry.SettingsFor("tabs"); got.Delimiter != "\t" || got.SecondaryDelimiter != ";" {
+		t.Errorf("tabs: got %+v", got)
+	}
+	if got := registry.SettingsFor("other"); got.Delimiter != "|" || got.SecondaryDelimiter != "" {
+		t.Errorf("other: got %+v", got)
+	}
+	cex := ParseCEX(CTSParams{Sourcetext: "#!ctsdata\nurn:cts:citeArch:groupA.work1.ed1:1.1|One#1\n", Delimiter: "|"})
+	if len(cex.CTSData.Text) != 1 || cex.CTSData.Text[0] != "One#1" {
+		t.Errorf("declared delimiter |: got %v", cex.CTSData.Text)
+	}
+}
+
+//Lines that cannot be parsed are reported with their block, line number and text instead of ending the program.
+func TestParseDiagnostics(t *testing.T) {
+	for _, test := range []struct {
+		source, block string
+		line          int
+		reason        string
+	}{
+		{"#!ctsdata\nurn:cts:citeArch:groupA.work1.ed1:1.1#One#extra\n", "ctsdata", 2, "expected 2 columns, found 3"},
+		{"#!ctsdata\nurn:cts:citeArch:groupA.work1.ed1:1.1#One.\nnot a urn#Two.\n", "ctsdata", 3, "not a CTS URN"},
+		{"#!citelibrary\nname#Test\ncolour#blue\n", "citelibrary", 3, "unknown key colour"},
+		{"#!ctscatalog\nurn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online\nurn:cts:citeArch:groupA.work1.ed1:#book\n", "ctscatalog", 3, "expected 7 to 8 columns, found 2"},
+		{"#!ctsdata\nurn:cts:citeArch:groupA.work1.ed1:1.1#One.\n\n#!unknown\nline\n", "unknown", 4, "unknown block label"},
+	} {
+		diagnostics := ParseCEX(CTSParams{Sourcetext: test.source, Delimiter: "#"}).Diagnostics
+		if len(diagnostics) != 1 {
+			t.Errorf("%q: got diagnostics %v, want one", test.source, diagnostics)
+			continue
+		}
+		if got := diagnostics[0]; got.Block != test.block || got.Line != test.line || !strings.HasPrefix(got.Reason, test.reason) {
+			t.Errorf("%q: got %v, want line %d of #!%s: %s", test.source, got.Error(), test.line, test.block, test.reason)
+		}
+	}
+}
+
+//A strict library with bad lines is rejected without being parsed again for every request, and reloaded once
+//even if an earlier version is still loaded.
+func TestStrictRejection(t *testing.T) {
+	var mutex sync.Mutex
+	fetches, content := 0, registrySource
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		fetches++
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	config := `{"cex_source": "` + server.URL + `/", "libraries": {"strict": {"strictness": "strict"}}}`
+	if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	registry := NewLibraryRegistry(configFile)
+	if _, err := registry.Library("strict"); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	content = registrySource + "urn:cts:citeArch:groupA.work1.ed1:1.3#Three#extra\n"
+	mutex.Unlock()
+	_, err := registry.Reload("strict")
+	libraryError, ok := err.(*LibraryError)
+	if !ok || len(libraryError.Diagnostics) != 1 || libraryError.Diagnostics[0].Line != 8 {
+		t.Fatalf("got %v, want a rejection for line 8", err)
+	}
+	if library, err := registry.Library("strict"); err != nil || len(library.WorkURNs) != 1 {
+		t.Errorf("the library loaded before the rejection is not served: %v", err)
+	}
+	mutex.Lock()
+	content = registrySource
+	mutex.Unlock()
+	if reloaded, err := registry.ReloadAll(); err != nil || len(reloaded) != 1 {
+		t.Errorf("ReloadAll: got %v, %v", reloaded, err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if fetches != 3 {
+		t.Errorf("strict.cex fetched %d times, want 3", fetches)
+	}
+}
+
+//Valid CTS URNs are split into their components and printed as given; invalid ones are rejected.
+func TestParseCTSURN(t *testing.T) {
+	for _, test := range []struct {
+		urn, stem, passage string
+		isRange            bool
+	}{
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1.1", "urn:cts:greekLit:tlg0012.tlg001.msA", "1.1", false},
+		{"urn:cts:greekLit:tlg0012.tlg001:", "urn:cts:greekLit:tlg0012.tlg001", "", false},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA.tokens:1.1.2", "urn:cts:greekLit:tlg0012.tlg001.msA.tokens", "1.1.2", false},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1.1-1.10", "urn:cts:greekLit:tlg0012.tlg001.msA", "1.1-1.10", true},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1.1@μῆνιν[1]-1.2@θεὰ", "urn:cts:greekLit:tlg0012.tlg001.msA", "1.1@μῆνιν[1]-1.2@θεὰ", true},
+	} {
+		urn, err := ParseCTSURN(test.urn)
+		if err != nil {
+			t.Errorf("%v: %v", test.urn, err)
+			continue
+		}
+		if urn.Stem() != test.stem || urn.Passage() != test.passage || urn.Range != test.isRange || urn.String() != test.urn {
+			t.Errorf("%v: got stem %v, passage %v, range %v, string %v", test.urn, urn.Stem(), urn.Passage(), urn.Range, urn.String())
+		}
+	}
+	for _, invalid := range []string{"urn:cts:greekLit", "urn:cite2:hmt:msA.v1:1r", "urn:cts::tlg0012.tlg001:", "urn:cts:greekLit:tlg0012..msA:1",
+		"urn:cts:greekLit:a.b.c.d.e:1", "urn:cts:greekLit:tlg0012.tlg001.msA:1-2-3", "urn:cts:greekLit:tlg0012.tlg001.msA:1..2",
+		"urn:cts:greekLit:tlg0012.tlg001.msA:1@", "urn:cts:greekLit:tlg0012.tlg001.msA:1@a[0]", "urn:cts:greekLit:tlg0012.tlg001.msA:1@a[1", "urn:cts:a:b:c:d"} {
+		if _, err := ParseCTSURN(invalid); err == nil {
+			t.Errorf("%v accepted", invalid)
+		}
+	}
+}
+
+//A URN contains another if the other is in the same work and its passage lies at or below the passage of the first.
+func TestCTSURNContains(t *testing.T) {
+	for _, test := range []struct {
+		urn, other string
+		contains   bool
+	}{
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:", "urn:cts:greekLit:tlg0012.tlg001.msA:1.1", true},
+		{"urn:cts:greekLit:tlg0012.tlg001:", "urn:cts:greekLit:tlg0012.tlg001.msA:1.1", true},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1", "urn:cts:greekLit:tlg0012.tlg001.msA:1.1", true},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1.1", "urn:cts:greekLit:tlg0012.tlg001.msA:1.1", true},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1.1", "urn:cts:greekLit:tlg0012.tlg001.msA:1", false},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1", "urn:cts:greekLit:tlg0012.tlg001.msA:10.1", false},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1.1", "urn:cts:greekLit:tlg0012.tlg001.msB:1.1", false},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1.1", "urn:cts:latinLit:tlg0012.tlg001.msA:1.1", false},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1.1", "urn:cts:greekLit:tlg0012.tlg001.msA:", false},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1", "urn:cts:greekLit:tlg0012.tlg001.msA:1.1-1.5", true},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1", "urn:cts:greekLit:tlg0012.tlg001.msA:1.1-2.5", false},
+	} {
+		urn, _ := ParseCTSURN(test.urn)
+		other, _ := ParseCTSURN(test.other)
+		if got := urn.Contains(other); got != test.contains {
+			t.Errorf("%v contains %v: got %v", test.urn, test.other, got)
+		}
+	}
+}
+
+//Loads the CEX source as a library through a temporary file.
+func testLibrary(t *testing.T, source string) *Library {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "test.cex")
+	if err := os.WriteFile(file, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	library, err := LoadLibrary("test", file, LibraryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return library
+}
+
+//CEX source of one work whose books have different numbers of lines.
+const spanSource = `#!ctsdata
+urn:cts:citeArch:groupA.work1.ed1:1.1#One one.
+urn:cts:citeArch:groupA.work1.ed1:1.2#One two.
+urn:cts:citeArch:groupA.work1.ed1:2.1#Two one.
+urn:cts:citeArch:groupA.work1.ed1:2.2#Two two.
+urn:cts:citeArch:groupA.work1.ed1:10.1#Ten one.
+`
+
+//The citation index finds the nodes of a passage, a container or a range at any depth without matching strings.
+func TestSpan(t *testing.T) {
+	work := testLibrary(t, spanSource).Works["urn:cts:citeArch:groupA.work1.ed1"]
+	for _, test := range []struct {
+		passage     string
+		first, last int
+		found       bool
+	}{
+		{"", 0, 4, true},
+		{"1", 0, 1, true},
+		{"1.2", 1, 1, true},
+		{"2", 2, 3, true},
+		{"1.2-2.1", 1, 2, true},
+		{"1-10", 0, 4, true},
+		{"10.1", 4, 4, true},
+		{"3", 0, 0, false},
+		{"1.3", 0, 0, false},
+		{"2.1-1.1", 0, 0, false},
+	} {
+		urn, _ := ParseCTSURN("urn:cts:citeArch:groupA.work1.ed1:" + test.passage)
+		first, last, found := work.Span(urn)
+		if found != test.found || found && (first != test.first || last != test.last) {
+			t.Errorf("%v: got %d to %d, found %v", test.passage, first, last, found)
+		}
+	}
+	nodes := work.Nodes(0, 4, nil)
+	if nodes[0].Previous[0] != "" || nodes[0].Next[0] != work.URN[1] || nodes[4].Next[0] != "" || nodes[4].Index != 5 {
+		t.Errorf("neighbours at the ends of the work: got %+v and %+v", nodes[0], nodes[4])
+	}
+}
+
+//Hyphens in subreferences do not make ranges; a hyphen followed by a reference with its own subreference, or after an index, still does.
+func TestParseCTSURNSubreferenceHyphen(t *testing.T) {
+	for _, test := range []struct {
+		urn, begin, end string
+		isRange         bool
+	}{
+		{"urn:cts:citeArch:groupA.work1.ed1:1.1@well-known", "well-known", "", false},
+		{"urn:cts:citeArch:groupA.work1.ed1:1.1@well-known[2]", "well-known", "", false},
+		{"urn:cts:citeArch:groupA.work1.ed1:1.1@well-known-1.2@x", "well-known", "x", true},
+		{"urn:cts:citeArch:groupA.work1.ed1:1.2@point-2.1@One", "point", "One", true},
+		{"urn:cts:citeArch:groupA.work1.ed1:1.2@point-2.1", "point-2.1", "", false},
+		{"urn:cts:citeArch:groupA.work1.ed1:1.2@point[1]-2.1", "point", "", true},
+		{"urn:cts:citeArch:groupA.work1.ed1:1.2@o[2]-2.1@well-known", "o", "well-known", true},
+		{"urn:cts:citeArch:groupA.work1.ed1:1.2-2.1@well-known[1]", "", "well-known", true},
+		{"urn:cts:greekLit:tlg0012.tlg002.msA:1@well-known", "well-known", "", false},
+		{"urn:cts:greekLit:tlg0012.tlg002.msA:1@a-b[2]", "a-b", "", false},
+		{"urn:cts:greekLit:tlg0012.tlg002.msA:1@a-2@b", "a", "b", true},
+	} {
+		urn, err := ParseCTSURN(test.urn)
+		if err != nil {
+			t.Errorf("%v: %v", test.urn, err)
+			continue
+		}
+		if urn.Begin.Subreference != test.begin || urn.End.Subreference != test.end || urn.Range != test.isRange {
+			t.Errorf("%v: got %q and %q, range %v", test.urn, urn.Begin.Subreference, urn.End.Subreference, urn.Range)
+		}
+	}
+}
+
+//Subreferences cut the text of their node at character, not byte, offsets; the index selects the occurrence of the token.
+func TestSubstrings(t *testing.T) {
+	work := testLibrary(t, "#!ctsdata\nurn:cts:greekLit:tlg0012.tlg001.msA:1.1#μῆνιν ἄειδε θεὰ μῆνιν\nurn:cts:greekLit:tlg0012.tlg001.msA:1.2#οὐλομένην ἣ μυρί᾽\n").Works["urn:cts:greekLit:tlg0012.tlg001.msA"]
+	for _, test := range []struct {
+		passage    string
+		position   int
+		text       string
+		start, end int
+		fails      bool
+	}{
+		{"1.1@μῆνιν", 0, "μῆνιν", 0, 5, false},
+		{"1.1@μῆνιν[2]", 0, "μῆνιν", 16, 21, false},
+		{"1.1@ἄειδε-1.1@θεὰ", 0, "ἄειδε θεὰ", 6, 15, false},
+		{"1.1@θεὰ[1]-1.2", 0, "θεὰ μῆνιν", 12, 21, false},
+		{"1.1@μῆνιν[3]", 0, "", 0, 0, true},
+		{"1.1@θεὰ-1.1@ἄειδε", 0, "", 0, 0, true},
+	} {
+		urn, _ := ParseCTSURN("urn:cts:greekLit:tlg0012.tlg001.msA:" + test.passage)
+		first, last, _ := work.Span(urn)
+		substrings, err := work.Substrings(urn, first, last)
+		if (err != nil) != test.fails {
+			t.Errorf("%v: error %v", test.passage, err)
+			continue
+		}
+		if got := substrings[test.position]; !test.fails && (got.Text != test.text || got.Start != test.start || got.End != test.end) {
+			t.Errorf("%v: got %+v", test.passage, got)
+		}
+	}
+}
+
+//CITE2 URNs name collections, properties, objects and ranges of objects.
+func TestParseCite2URN(t *testing.T) {
+	for _, test := range []struct {
+		urn, collection, property, object string
+		isRange                           bool
+	}{
+		{"urn:cite2:hmt:msA.v1:", "urn:cite2:hmt:msA.v1:", "", "", false},
+		{"urn:cite2:hmt:msA:", "urn:cite2:hmt:msA:", "", "", false},
+		{"urn:cite2:hmt:msA.v1:1r", "urn:cite2:hmt:msA.v1:", "", "1r", false},
+		{"urn:cite2:hmt:msA.v1.label:", "urn:cite2:hmt:msA.v1:", "label", "", false},
+		{"urn:cite2:hmt:msA.v1:1r-2v", "urn:cite2:hmt:msA.v1:", "", "1r-2v", true},
+	} {
+		urn, err := ParseCite2URN(test.urn)
+		if err != nil {
+			t.Errorf("%v: %v", test.urn, err)
+			continue
+		}
+		if urn.CollectionURN() != test.collection || urn.Property != test.property || urn.Object() != test.object || urn.Range != test.isRange || urn.String() != test.urn {
+			t.Errorf("%v: got collection %v, property %v, object %v, range %v, string %v", test.urn, urn.CollectionURN(), urn.Property, urn.Object(), urn.Range, urn.String())
+		}
+	}
+	for _, invalid := range []string{"urn:cite2:hmt:msA.v1", "urn:cts:hmt:msA.v1:1r", "urn:cite2::msA.v1:1r", "urn:cite2:hmt:a.b.c.d:", "urn:cite2:hmt:msA.v1:1r-", "urn:cite2:hmt:msA.v1:1r-2v-3r"} {
+		if _, err := ParseCite2URN(invalid); err == nil {
+			t.Errorf("%v accepted", invalid)
+		}
+	}
+}
+
+//Number values follow the CEX specification; ParseFloat alone would accept NaN, infinities, hexadecimal numbers and exponents.
+func TestTypedValueNumber(t *testing.T) {
+	property := CiteProperty{URN: "urn:cite2:hmt:msA.v1.sequence:", Type: "Number"}
+	for value, valid := range map[string]bool{"1": true, "-12": true, "+3": true, "0.5": true, "12.25": true,
+		"NaN": false, "Inf": false, "-infinity": false, "0x1p-2": false, "1e3": false, ".5": false, "5.": false, "1.2.3": false, "-+5": false} {
+		_, err := typedValue(property, value)
+		if (err == nil) != valid {
+			t.Errorf("typedValue(%q): error %v, want valid %v", value, err, valid)
+		}
+	}
+}
+
+//Objects are built from the records of #!citedata and sorted by the ordering property; records with invalid values are reported.
+func TestBuildCollections(t *testing.T) {
+	source := strings.Replace(fullSource, "urn:cite2:hmt:msA.v1:1v#Folio 1 verso#2.5#verso", "urn:cite2:hmt:msA.v1:1v#Folio 1 verso#0.5#verso\nurn:cite2:hmt:msA.v1:2r#Folio 2 recto#NaN#recto\nurn:cite2:hmt:msA.v1:2v#Folio 2 verso#3#left", 1)
+	collections, urns, diagnostics := BuildCollections(ParseCEX(CTSParams{Sourcetext: source}))
+	if len(urns) != 1 || len(diagnostics) != 2 {
+		t.Fatalf("got collections %v and diagnostics %v, want one collection and two diagnostics", urns, diagnostics)
+	}
+	objects := collections["urn:cite2:hmt:msA.v1:"].Objects
+	if len(objects) != 2 || objects[0].URN != "urn:cite2:hmt:msA.v1:1v" || objects[0].Label != "Folio 1 verso" {
+		t.Errorf("got objects %+v, want 1v before 1r", objects)
+	}
+}
+
+//A response JSON cannot represent is answered with an Exception instead of an empty body.
+func TestWriteJSONEncodingError(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	result := ObjectResponse{Status: "Success", Service: "/objects", Objects: []CiteObject{{Properties: []PropertyValue{{Value: math.NaN()}}}}}
+	writeJSON(recorder, result)
+	var exception LibraryExceptionResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &exception); err != nil {
+		t.Fatalf("response is not JSON: %q", recorder.Body.String())
+	}
+	if exception.Status != "Exception" || exception.Service != "/objects" {
+		t.Errorf("got status %q and service %q, want Exception and /objects", exception.Status, exception.Service)
+	}
+}
+
+//Relations are found through any URN that overlaps their subject or object: containers, ranges and single objects, optionally for one verb.
+func TestRelationsOf(t *testing.T) {
+	source := strings.Replace(fullSource, "#!relations\n", "#!relations\nurn:cts:citeArch:groupA.work1.ed1:1.2#urn:cite2:cite:verbs.v1:commentedOn:#urn:cite2:hmt:msA.v1:1v\n", 1)
+	library := testLibrary(t, source)
+	for _, test := range []struct {
+		urn, verb string
+		relations int
+	}{
+		{"urn:cts:citeArch:groupA.work1.ed1:", "", 2},
+		{"urn:cts:citeArch:groupA.work1.ed1:1.1", "", 1},
+		{"urn:cts:citeArch:groupA.work1.ed1:1.2", "", 2},
+		{"urn:cts:citeArch:groupA.work1.ed1:1.2", "urn:cite2:dse:verbs.v1:appearsOn", 1},
+		{"urn:cts:citeArch:groupA.work1.ed1:1.2", "urn:cite2:cite:verbs.v1:unknown:", 0},
+		{"urn:cts:citeArch:groupA.work1.ed2:1.1", "", 0},
+		{"urn:cite2:hmt:msA.v1:1r", "", 1},
+		{"urn:cite2:hmt:msA.v1:1r-1v", "", 2},
+		{"urn:cite2:hmt:msA:", "", 2},
+		{"urn:cite2:hmt:msB.v1:1r", "", 0},
+	} {
+		if got := library.RelationsOf(test.urn, test.verb); len(got) != test.relations {
+			t.Errorf("%v, verb %q: got %v, want %d relations", test.urn, test.verb, got, test.relations)
+		}
+	}
+}
+
+//CEX source of a DSE collection of two records on two pages, whose image regions are extensions of the same image.
+const dseSource = `#!ctsdata
+urn:cts:greekLit:tlg0012.tlg001.msA:1.1#μῆνιν ἄειδε θεὰ
+urn:cts:greekLit:tlg0012.tlg001.msA:1.2#οὐλομένην
+
+#!citecollections
+URN#Description#Labelling property#Ordering property#License
+urn:cite2:hmt:va_dse.v1:#DSE records#urn:cite2:hmt:va_dse.v1.label:##CC-BY
+
+#!citeproperties
+Property#Label#Type#Authority list
+urn:cite2:hmt:va_dse.v1.urn:#DSE record#Cite2Urn#
+urn:cite2:hmt:va_dse.v1.label:#Label#String#
+urn:cite2:hmt:va_dse.v1.passage:#Passage#CtsUrn#
+urn:cite2:hmt:va_dse.v1.imageroi:#Image region#Cite2Urn#
+urn:cite2:hmt:va_dse.v1.surface:#Surface#Cite2Urn#
+
+#!citedata
+urn#label#passage#imageroi#surface
+urn:cite2:hmt:va_dse.v1:1#Line 1.1#urn:cts:greekLit:tlg0012.tlg001.msA:1.1#urn:cite2:hmt:vaimg.v1:VA012RN@0.1,0.1,0.5,0.05#urn:cite2:hmt:msA.v1:12r
+urn:cite2:hmt:va_dse.v1:2#Line 1.2#urn:cts:greekLit:tlg0012.tlg001.msA:1.2#urn:cite2:hmt:vaimg.v1:VA012RN@0.1,0.2,0.5,0.05#urn:cite2:hmt:msA.v1:12v
+
+#!datamodels
+Collection#Model#Label#Description
+urn:cite2:hmt:va_dse.v1:#urn:cite2:cite:datamodels.v1:dse#DSE#Diplomatic scholarly edition
+`
+
+//DSE records are found by passage, surface or image; an image region only finds the record of that region.
+func TestDSEOf(t *testing.T) {
+	library := testLibrary(t, dseSource)
+	if len(library.DSE) != 2 {
+		t.Fatalf("got DSE records %v, want two", library.DSE)
+	}
+	for _, test := range []struct {
+		urn     string
+		records []string
+	}{
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1.1", []string{"urn:cite2:hmt:va_dse.v1:1"}},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1", []string{"urn:cite2:hmt:va_dse.v1:1", "urn:cite2:hmt:va_dse.v1:2"}},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1.1-1.2", []string{"urn:cite2:hmt:va_dse.v1:1", "urn:cite2:hmt:va_dse.v1:2"}},
+		{"urn:cite2:hmt:msA.v1:12v", []string{"urn:cite2:hmt:va_dse.v1:2"}},
+		{"urn:cite2:hmt:vaimg.v1:VA012RN", []string{"urn:cite2:hmt:va_dse.v1:1", "urn:cite2:hmt:va_dse.v1:2"}},
+		{"urn:cite2:hmt:vaimg.v1:VA012RN@0.1,0.2,0.5,0.05", []string{"urn:cite2:hmt:va_dse.v1:2"}},
+		{"urn:cite2:hmt:msA.v1:13r", nil},
+	} {
+		var got []string
+		for _, record := range library.DSEOf(test.urn) {
+			got = append(got, record.URN)
+		}
+		if strings.Join(got, " ") != strings.Join(test.records, " ") {
+			t.Errorf("%v: got %v, want %v", test.urn, got, test.records)
+		}
+	}
+}
+
+//Object URNs may carry an extension after @, like the region of an image.
+func TestParseCite2URNExtension(t *testing.T) {
+	urn, err := ParseCite2URN("urn:cite2:hmt:vaimg.v1:VA012RN@0.1,0.2,0.5,0.05")
+	if err != nil || urn.Begin != "VA012RN" || urn.Extension != "0.1,0.2,0.5,0.05" || urn.String() != "urn:cite2:hmt:vaimg.v1:VA012RN@0.1,0.2,0.5,0.05" {
+		t.Errorf("got %+v, %v", urn, err)
+	}
+	image, _ := ParseCite2URN("urn:cite2:hmt:vaimg.v1:VA012RN")
+	if !image.Contains(urn) || urn.Contains(image) {
+		t.Errorf("the image should contain its region, not the other way round")
+	}
+}
+
+//CEX source of an ORCA collection whose rows analyse the tokens of line 1.1 out of text order, and a work of a single node.
+const orcaSource = `#!ctscatalog
+urn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online#lang
+urn:cts:greekLit:tlg0012.tlg001.msA:#book,line#Homer#Iliad#Venetus A##true#grc
+urn:cts:greekLit:tlg0012.tlg002.msA:#line#Homer#Odyssey#Venetus A##true#grc
+
+#!ctsdata
+urn:cts:greekLit:tlg0012.tlg001.msA:1.1#μῆνιν ἄειδε θεὰ
+urn:cts:greekLit:tlg0012.tlg001.msA:1.2#οὐλομένην
+urn:cts:greekLit:tlg0012.tlg002.msA:1#ἄνδρα μοι ἔννεπε
+
+#!citecollections
+URN#Description#Labelling property#Ordering property#License
+urn:cite2:hmt:tokens.v1:#Tokens#urn:cite2:hmt:tokens.v1.label:##CC-BY
+
+#!citeproperties
+Property#Label#Type#Authority list
+urn:cite2:hmt:tokens.v1.urn:#Token#Cite2Urn#
+urn:cite2:hmt:tokens.v1.label:#Label#String#
+urn:cite2:hmt:tokens.v1.passage:#Passage#CtsUrn#
+urn:cite2:hmt:tokens.v1.analysis:#Analysis#Cite2Urn#
+urn:cite2:hmt:tokens.v1.deformation:#Deformation#String#
+
+#!citedata
+urn#label#passage#analysis#deformation
+urn:cite2:hmt:tokens.v1:t3#Token 3#urn:cts:greekLit:tlg0012.tlg001.msA:1.2@οὐλομένην#urn:cite2:hmt:lemmata.v1:oulomenos#οὐλομένην
+urn:cite2:hmt:tokens.v1:t2#Token 2#urn:cts:greekLit:tlg0012.tlg001.msA:1.1@ἄειδε#urn:cite2:hmt:lemmata.v1:aeido#ἄειδε
+urn:cite2:hmt:tokens.v1:t1#Token 1#urn:cts:greekLit:tlg0012.tlg001.msA:1.1@μῆνιν#urn:cite2:hmt:lemmata.v1:menis#μῆνιν
+
+#!datamodels
+Collection#Model#Label#Description
+urn:cite2:hmt:tokens.v1:#urn:cite2:cite:datamodels.v1:orca#Tokens#Analyses of tokens
+`
+
+//The analyses of one node are numbered in text order, not in the order of their rows, and /orca lists them in that order.
+func TestAnalyticalExemplarOrder(t *testing.T) {
+	library := testLibrary(t, orcaSource)
+	work, found := library.Works["urn:cts:greekLit:tlg0012.tlg001.msA.tokens"]
+	if !found {
+		t.Fatalf("no analytical exemplar in %v", library.WorkURNs)
+	}
+	want := []string{"urn:cts:greekLit:tlg0012.tlg001.msA.tokens:1.1.1#μῆνιν", "urn:cts:greekLit:tlg0012.tlg001.msA.tokens:1.1.2#ἄειδε", "urn:cts:greekLit:tlg0012.tlg001.msA.tokens:1.2.1#οὐλομένην"}
+	for i, node := range want {
+		if i >= len(work.URN) || work.URN[i]+"#"+work.Text[i] != node {
+			t.Errorf("node %d: got %v, want %v", i, work.URN, node)
+		}
+	}
+	var analyses []string
+	for _, record := range library.ORCAOf("urn:cts:greekLit:tlg0012.tlg001.msA:1.1") {
+		analyses = append(analyses, record.URN)
+	}
+	if strings.Join(analyses, " ") != "urn:cite2:hmt:tokens.v1:t1 urn:cite2:hmt:tokens.v1:t2" {
+		t.Errorf("got analyses %v, want t1 before t2", analyses)
+	}
+}
+
+//Serves the request with the handler registered for the route pattern, after storing the library in the registry.
+func serveTest(t *testing.T, library *Library, pattern string, handler func(http.ResponseWriter, *http.Request), path string) string {
+	t.Helper()
+	registry.mutex.Lock()
+	registry.Libraries[library.Name] = library
+	registry.mutex.Unlock()
+	router := mux.NewRouter()
+	router.HandleFunc(pattern, handler)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	return recorder.Body.String()
+}
+
+//The first, last and only node of a work with one node has no previous and no next node.
+func TestOneNodeWork(t *testing.T) {
+	library := testLibrary(t, orcaSource)
+	for _, test := range []struct {
+		pattern string
+		handler func(http.ResponseWriter, *http.Request)
+		urn     string
+	}{
+		{"/{CEX}/texts/first/{URN}", ReturnFirst, "urn:cts:greekLit:tlg0012.tlg002.msA:"},
+		{"/{CEX}/texts/last/{URN}", ReturnLast, "urn:cts:greekLit:tlg0012.tlg002.msA:"},
+		{"/{CEX}/texts/{URN}", ReturnPassage, "urn:cts:greekLit:tlg0012.tlg002.msA:1"},
+	} {
+		var result NodeResponse
+		body := serveTest(t, library, test.pattern, test.handler, strings.Replace(strings.Replace(test.pattern, "{CEX}", "test", 1), "{URN}", test.urn, 1))
+		if err := json.Unmarshal([]byte(body), &result); err != nil || result.Status != "Success" || len(result.Nodes) != 1 {
+			t.Errorf("%v: got %v", test.pattern, body)
+			continue
+		}
+		if node := result.Nodes[0]; node.URN[0] != "urn:cts:greekLit:tlg0012.tlg002.msA:1" || node.Previous[0] != "" || node.Next[0] != "" {
+			t.Errorf("%v: got %+v", test.pattern, node)
+		}
+	}
+}
+
+//Each filter of a /catalog request narrows the entries, ignoring case.
+func TestCatalogFilter(t *testing.T) {
+	catalog := Catalog{CatalogEntries: []CatalogEntry{
+		{URN: "urn:cts:greekLit:tlg0012.tlg001.msA:", GroupName: "Homeric Poetry", WorkTitle: "Iliad", Online: "true", Lang: "grc"},
+		{URN: "urn:cts:greekLit:tlg0012.tlg001.msA.tokens:", GroupName: "Homeric Poetry", WorkTitle: "Iliad", Online: "true", Lang: "grc"},
+		{URN: "urn:cts:greekLit:tlg0012.tlg002.perseus:", GroupName: "Homeric Poetry", WorkTitle: "Odyssey", Online: "false", Lang: "eng"},
+		{URN: "urn:cts:latinLit:phi0690.phi003.perseus:", GroupName: "Vergil", WorkTitle: "Aeneid", Online: "true", Lang: "lat"},
+	}}
+	for _, test := range []struct {
+		filter CatalogFilter
+		want   int
+	}{
+		{CatalogFilter{}, 4},
+		{CatalogFilter{Group: "tlg0012"}, 3},
+		{CatalogFilter{Group: "vergil"}, 1},
+		{CatalogFilter{Work: "tlg001"}, 2},
+		{CatalogFilter{Work: "tlg0012.tlg002"}, 1},
+		{CatalogFilter{Work: "AENEID"}, 1},
+		{CatalogFilter{Lang: "grc"}, 2},
+		{CatalogFilter{Online: "false"}, 1},
+		{CatalogFilter{Type: "version"}, 3},
+		{CatalogFilter{Type: "exemplar"}, 1},
+		{CatalogFilter{Group: "tlg0012", Lang: "eng"}, 1},
+		{CatalogFilter{Group: "tlg9999"}, 0},
+	} {
+		if got := catalog.Filter(test.filter); len(got) != test.want {
+			t.Errorf("%+v: got %v entries, want %v", test.filter, len(got), test.want)
+		}
+	}
+}
+
+//A catalog and text data that disagree in every way CheckCatalog looks for.
+const consistencySource = `#!ctscatalog
+urn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online#lang
+urn:cts:greekLit:tlg0012.tlg001.msA:#book,line#Homeric Poetry#Iliad#Venetus A##true#grc
+urn:cts:greekLit:tlg0012.tlg002.msA:#book,line#Homeric Poetry#Odyssey#Venetus A##true#grc
+urn:cts:greekLit:tlg0012.tlg003:#book,line#Homeric Poetry#Hymns###true#grc
+
+#!ctsdata
+urn:cts:greekLit:tlg0012.tlg001.msA:1.1#μῆνιν ἄειδε θεὰ
+urn:cts:greekLit:tlg0012.tlg001.msA:1.1#duplicate
+urn:cts:greekLit:tlg0012.tlg001.msA:1#too few levels
+urn:cts:greekLit:tlg0012.tlg001.msA:2#too few levels again
+urn:cts:greekLit:tlg0012.tlg004.msA:1.1#not catalogued
+`
+
+//Each mismatch between #!ctscatalog and #!ctsdata is reported once, with the reason of the mismatch.
+func TestCheckCatalog(t *testing.T) {
+	library := testLibrary(t, consistencySource)
+	want := []struct {
+		text   string
+		reason string
+	}{
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1.1", "duplicate node URN"},
+		{"urn:cts:greekLit:tlg0012.tlg002.msA:", "catalogued as online but has no nodes"},
+		{"urn:cts:greekLit:tlg0012.tlg003:", "not the URN of a version or exemplar"},
+		{"urn:cts:greekLit:tlg0012.tlg001.msA:1", "1 citation levels, but the citationScheme book,line has 2"},
+		{"urn:cts:greekLit:tlg0012.tlg004.msA:1.1", "which is not in #!ctscatalog"},
+	}
+	if len(library.Inconsistencies) != len(want) {
+		t.Fatalf("got %v inconsistencies, want %v: %v", len(library.Inconsistencies), len(want), library.Inconsistencies)
+	}
+	for _, problem := range want {
+		found := false
+		for _, inconsistency := range library.Inconsistencies {
+			if inconsistency.Text == problem.text && strings.Contains(inconsistency.Reason, problem.reason) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("missing %v: %v in %v", problem.text, problem.reason, library.Inconsistencies)
+		}
+	}
+}
+
+//validate exits with 0 for valid files, 1 for files with problems or that cannot be read, and 2 for bad usage.
+func TestValidateExitCodes(t *testing.T) {
+	directory := t.TempDir()
+	valid := filepath.Join(directory, "valid.cex")
+	inconsistent := filepath.Join(directory, "inconsistent.cex")
+	if err := os.WriteFile(valid, []byte(fullSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(inconsistent, []byte(consistencySource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout, os.Stderr = devnull, devnull //the reports are not checked here
+	defer func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		devnull.Close()
+	}()
+	for _, test := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{valid}, 0},
+		{[]string{"-json", valid}, 0},
+		{[]string{inconsistent}, 1},
+		{[]string{valid, inconsistent}, 1},
+		{[]string{filepath.Join(directory, "missing.cex")}, 1},
+		{[]string{}, 2},
+		{[]string{"-unknown", valid}, 2},
+	} {
+		if got := Validate(test.args); got != test.want {
+			t.Errorf("%v: got exit code %v, want %v", test.args, got, test.want)
+		}
+	}
+}
+
+//CEX source without #!cexversion and #!citelibrary, whose catalog has no lang column.
+const undeclaredSource = `#!ctscatalog
+urn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online
+urn:cts:citeArch:groupA.work1.ed1:#book,line#Group A#Work 1#Edition 1##true
+
+#!ctsdata
+urn:cts:citeArch:groupA.work1.ed1:1.1#One.
+urn:cts:citeArch:groupA.work1.ed1:1.2#Two.
+`
+
+//Parses source, writes it as CEX and parses the result again. The second parse has to find the same version, no new problems
+//and data that is written exactly as the first time.
+func TestWriteCEXRoundTrip(t *testing.T) {
+	for name, source := range map[string]string{"declared": fullSource, "undeclared": undeclaredSource} {
+		for _, delimiters := range [][2]string{{"#", ","}, {"|", ";"}} {
+			first := ParseCEX(CTSParams{Sourcetext: source})
+			written, err := WriteCEX(first, delimiters[0], delimiters[1])
+			if err != nil {
+				t.Fatalf("%s: WriteCEX: %v", name, err)
+			}
+			second := ParseCEX(CTSParams{Sourcetext: written, Delimiter: delimiters[0], SecondaryDelimiter: delimiters[1]})
+			if second.Version != first.Version {
+				t.Errorf("%s: version %q after the round trip, want %q", name, second.Version, first.Version)
+			}
+			if len(second.Diagnostics) > len(first.Diagnostics) {
+				t.Errorf("%s %q: new problems after the round trip: %v", name, delimiters[0], second.Diagnostics[len(first.Diagnostics):])
+			}
+			rewritten, err := WriteCEX(second, delimiters[0], delimiters[1])
+			if err != nil {
+				t.Fatalf("%s: WriteCEX of the reparsed data: %v", name, err)
+			}
+			if rewritten != written {
+				t.Errorf("%s %q: round trip changed the library:\n%s\nwant\n%s", name, delimiters[0], rewritten, written)
+			}
+			if len(second.CTSData.URN) != len(first.CTSData.URN) || len(second.Catalog.CatalogEntries) != len(first.Catalog.CatalogEntries) {
+				t.Errorf("%s %q: nodes or catalog entries lost in the round trip", name, delimiters[0])
+			}
+		}
+	}
+}
+
+//A source without #!cexversion must not be given one, which would subject it to the rules of another version.
+func TestWriteCEXKeepsUndeclaredVersion(t *testing.T) {
+	written, err := WriteCEX(ParseCEX(CTSParams{Sourcetext: undeclaredSource}), "#", ",")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(written, "#!cexversion") {
+		t.Errorf("#!cexversion written for a source without one:\n%s", written)
+	}
+}
+
+//A subset holds every selected node once, even if the source repeats it, with the relations and collections that refer to it,
+//and is a CEX library that parses without problems.
+func TestSubset(t *testing.T) {
+	source := strings.Replace(fullSource, "#!ctsdata\n", "#!ctsdata\nurn:cts:citeArch:groupA.work1.ed1:1.1#Repeated.\n", 1)
+	library := testLibrary(t, source)
+	for _, test := range []struct {
+		urn       string
+		nodes     []string
+		relations int
+	}{
+		{"urn:cts:citeArch:groupA.work1.ed1:", []string{"urn:cts:citeArch:groupA.work1.ed1:1.1", "urn:cts:citeArch:groupA.work1.ed1:1.2"}, 1},
+		{"urn:cts:citeArch:groupA.work1.ed1:1.1", []string{"urn:cts:citeArch:groupA.work1.ed1:1.1"}, 1},
+		{"urn:cts:citeArch:groupA.work1.ed1:1.2", []string{"urn:cts:citeArch:groupA.work1.ed1:1.2"}, 1},
+	} {
+		urn, _ := ParseCTSURN(test.urn)
+		subset, err := library.Subset([]CTSURN{urn})
+		if err != nil {
+			t.Errorf("%v: %v", test.urn, err)
+			continue
+		}
+		if strings.Join(subset.CTSData.URN, " ") != strings.Join(test.nodes, " ") {
+			t.Errorf("%v: got nodes %v, want %v", test.urn, subset.CTSData.URN, test.nodes)
+		}
+		if len(subset.Relations) != test.relations || len(subset.Collections) != 1 || len(subset.Catalog.CatalogEntries) != 1 {
+			t.Errorf("%v: got %v relations, %v collections and %v catalog entries", test.urn, len(subset.Relations), len(subset.Collections), len(subset.Catalog.CatalogEntries))
+		}
+		written, err := WriteCEX(subset, "#", ",")
+		if err != nil {
+			t.Errorf("%v: WriteCEX: %v", test.urn, err)
+			continue
+		}
+		if reparsed := ParseCEX(CTSParams{Sourcetext: written}); len(reparsed.Diagnostics) > 0 {
+			t.Errorf("%v: the subset has problems: %v", test.urn, reparsed.Diagnostics)
+		}
+	}
+	for _, urn := range []string{"urn:cts:citeArch:groupA.work2.ed1:", "urn:cts:citeArch:groupA.work1.ed1:9"} {
+		parsed, _ := ParseCTSURN(urn)
+		if _, err := library.Subset([]CTSURN{parsed}); err == nil {
+			t.Errorf("%v: no error for a URN that is not in the library", urn)
+		}
+	}
+}
+
+//Problems found only after merging keep the source and line of the merged row.
+func TestMergedProblemsKeepSource(t *testing.T) {
+	first := ParseCEX(CTSParams{Sourcetext: fullSource})
+	second := ParseCEX(CTSParams{Sourcetext: "#!ctscatalog\nurn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online#lang\nurn:cts:citeArch:groupB.work2.ed1:#book#Group B#Work 2#Edition 1##true#eng\n\n#!ctsdata\nurn:cts:citeArch:groupB.work2.ed1:1.1#Too deep.\n"})
+	cex, conflicts := MergeCEX([]string{"first.cex", "second.cex"}, []CEXData{first, second})
+	library, err := buildLibrary("merged", "first.cex + second.cex", cex, conflicts, LibraryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range library.Inconsistencies {
+		if problem.Text == "urn:cts:citeArch:groupB.work2.ed1:1.1" {
+			if problem.Source != "second.cex" || problem.Line != 6 {
+				t.Errorf("got %v, want line 6 of second.cex", problem.Error())
+			}
+			return
+		}
+	}
+	t.Errorf("citation depth of second.cex not reported: %v", library.Inconsistencies)
+}
+
+//Conflicts between merged sources name the source that came first; values a source leaves out are no conflict.
+func TestMergeCEXConflicts(t *testing.T) {
+	library := func(name string, namespace string, data string) string {
+		return "#!citelibrary\n" + name + "namespace#citeArch#" + namespace + "\n\n#!ctscatalog\nurn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online#lang\n" +
+			"urn:cts:citeArch:groupA.work1.ed1:#book,line#Group A#Work 1#Edition 1##true#eng\n\n#!ctsdata\n" + data
+	}
+	const uri = "http://www.homermultitext.org/citens/citeArch"
+	for _, test := range []struct {
+		name        string
+		sources     [2]string
+		text        string
+		reason      string
+		libraryName string
+	}{
+		{"no conflict", [2]string{library("name#Corpus\n", uri, "urn:cts:citeArch:groupA.work1.ed1:1.1#One.\n"), library("", uri, "urn:cts:citeArch:groupA.work1.ed1:1.2#Two.\n")}, "", "", "Corpus"},
+		{"name given later", [2]string{library("", uri, "urn:cts:citeArch:groupA.work1.ed1:1.1#One.\n"), library("name#Corpus\n", uri, "urn:cts:citeArch:groupA.work1.ed1:1.2#Two.\n")}, "", "", "Corpus"},
+		{"name differs", [2]string{library("name#Corpus\n", uri, "urn:cts:citeArch:groupA.work1.ed1:1.1#One.\n"), library("name#Other\n", uri, "urn:cts:citeArch:groupA.work1.ed1:1.2#Two.\n")}, "Other", "library name differs from Corpus in first.cex", "Corpus"},
+		{"namespace differs", [2]string{library("", uri, "urn:cts:citeArch:groupA.work1.ed1:1.1#One.\n"), library("", "http://example.org/citeArch", "urn:cts:citeArch:groupA.work1.ed1:1.2#Two.\n")}, "http://example.org/citeArch", "namespace citeArch is " + uri + " in first.cex", ""},
+		{"node in both sources", [2]string{library("", uri, "urn:cts:citeArch:groupA.work1.ed1:1.1#One.\n"), library("", uri, "urn:cts:citeArch:groupA.work1.ed1:1.1#Again.\n")}, "urn:cts:citeArch:groupA.work1.ed1:1.1", "node URN is already used in first.cex", ""},
+	} {
+		parts := []CEXData{ParseCEX(CTSParams{Sourcetext: test.sources[0]}), ParseCEX(CTSParams{Sourcetext: test.sources[1]})}
+		merged, conflicts := MergeCEX([]string{"first.cex", "second.cex"}, parts)
+		if merged.CiteLibrary.Name != test.libraryName {
+			t.Errorf("%v: got library name %q, want %q", test.name, merged.CiteLibrary.Name, test.libraryName)
+		}
+		switch {
+		case test.reason == "" && len(conflicts) > 0:
+			t.Errorf("%v: got conflicts %v", test.name, conflicts)
+		case test.reason != "" && (len(conflicts) != 1 || conflicts[0].Source != "second.cex" || conflicts[0].Text != test.text || conflicts[0].Reason != test.reason):
+			t.Errorf("%v: got conflicts %v, want %v: %v in second.cex", test.name, conflicts, test.text, test.reason)
+		}
+	}
+}
+
+//A node repeated within one source is reported as a duplicate of that source, not as a conflict between sources.
+func TestMergeCEXDuplicateWithinSource(t *testing.T) {
+	first := ParseCEX(CTSParams{Sourcetext: strings.Replace(fullSource, "#!ctsdata\n", "#!ctsdata\nurn:cts:citeArch:groupA.work1.ed1:1.1#Repeated.\n", 1)})
+	second := ParseCEX(CTSParams{Sourcetext: "#!ctsdata\nurn:cts:citeArch:groupA.work1.ed1:1.3#Three.\n"})
+	cex, conflicts := MergeCEX([]string{"first.cex", "second.cex"}, []CEXData{first, second})
+	if len(conflicts) > 0 {
+		t.Errorf("got conflicts %v", conflicts)
+	}
+	library, err := buildLibrary("merged", "first.cex + second.cex", cex, conflicts, LibraryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var duplicates []ParseError
+	for _, problem := range library.Inconsistencies {
+		if strings.HasPrefix(problem.Reason, "duplicate node URN") {
+			duplicates = append(duplicates, problem)
+		}
+	}
+	if len(duplicates) != 1 || duplicates[0].Source != "first.cex" || duplicates[0].Text != "urn:cts:citeArch:groupA.work1.ed1:1.1" {
+		t.Errorf("got duplicates %v, want urn:cts:citeArch:groupA.work1.ed1:1.1 in first.cex", duplicates)
+	}
+	if work := library.Works["urn:cts:citeArch:groupA.work1.ed1"]; len(work.URN) != 3 {
+		t.Errorf("got nodes %v, want 1.1, 1.2 and 1.3", work.URN)
+	}
+}
+
+//CTS URNs of a library with #!citelibrary namespaces must use one of them; without declared namespaces every namespace is accepted.
+func TestLibraryParseCTSURN(t *testing.T) {
+	declared := testLibrary(t, fullSource)
+	undeclared := testLibrary(t, undeclaredSource)
+	for _, test := range []struct {
+		library *Library
+		urn     string
+		valid   bool
+	}{
+		{declared, "urn:cts:citeArch:groupA.work1.ed1:1.1", true},
+		{declared, "urn:cts:greekLit:tlg0012.tlg001.msA:1.1", false},
+		{declared, "urn:cts:citeArch", false},
+		{undeclared, "urn:cts:greekLit:tlg0012.tlg001.msA:1.1", true},
+		{undeclared, "urn:cts:citeArch:groupA.work1.ed1:1.1", true},
+	} {
+		if _, err := test.library.ParseCTSURN(test.urn); (err == nil) != test.valid {
+			t.Errorf("%v: error %v, want valid %v", test.urn, err, test.valid)
+		}
+	}
+}
+
+//Every response about a library carries its URN and license, and /library says so if there is no #!citelibrary block.
+func TestReturnLibrary(t *testing.T) {
+	for _, test := range []struct {
+		source  string
+		urn     string
+		message string
+	}{
+		{fullSource, "urn:cite2:test:cex.v1:full", ""},
+		{undeclaredSource, "", "The library has no #!citelibrary block."},
+	} {
+		library := testLibrary(t, test.source)
+		var result LibraryResponse
+		if err := json.Unmarshal([]byte(serveTest(t, library, "/{CEX}/library", ReturnLibrary, "/test/library")), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != "Success" || result.LibraryURN != test.urn || result.CiteLibrary.URN != test.urn || result.Message != test.message {
+			t.Errorf("got %+v, want library URN %q and message %q", result, test.urn, test.message)
+		}
+		var passage NodeResponse
+		if err := json.Unmarshal([]byte(serveTest(t, library, "/{CEX}/texts/{URN}", ReturnPassage, "/test/texts/urn:cts:citeArch:groupA.work1.ed1:1.1")), &passage); err != nil {
+			t.Fatal(err)
+		}
+		if passage.LibraryURN != test.urn {
+			t.Errorf("/texts: got library URN %q, want %q", passage.LibraryURN, test.urn)
+		}
+	}
+}
+
+//Each #!cexversion is parsed with the rules of its major version; unknown versions get the newest rules and a diagnostic.
+func TestRulesFor(t *testing.T) {
+	for _, test := range []struct {
+		version string
+		rules   string
+		valid   bool
+	}{
+		{"", "", true},
+		{"2.0", "2.0", true},
+		{"1.5", "2.0", true},
+		{"3.0", "3.x", true},
+		{"3.1.2", "3.x", true},
+		{"4.0", "3.x", false},
+		{"three", "3.x", false},
+	} {
+		rules, err := RulesFor(test.version)
+		if rules.Version != test.rules || (err == nil) != test.valid {
+			t.Errorf("RulesFor(%q): got rules %q and error %v, want rules %q and valid %v", test.version, rules.Version, err, test.rules, test.valid)
+		}
+	}
+}
+
+//Blocks the rules of a version require, alone or along with another block, are reported if they are missing.
+func TestCheckBlocks(t *testing.T) {
+	for _, test := range []struct {
+		source  string
+		missing []string
+	}{
+		{fullSource, nil},
+		{undeclaredSource, nil},
+		{"#!cexversion\n3.0\n\n#!citelibrary\nname#Test\n\n#!ctsdata\nurn:cts:citeArch:groupA.work1.ed1:1.1#One.\n", []string{"ctscatalog"}},
+		{"#!cexversion\n3.0\n\n#!relations\nurn:cts:citeArch:groupA.work1.ed1:1.1#urn:cite2:dse:verbs.v1:appearsOn:#urn:cite2:hmt:msA.v1:1r\n", []string{"citelibrary", "citecollections", "citeproperties", "citedata"}},
+		{"#!cexversion\n2.0\n\n#!ctsdata\nurn:cts:citeArch:groupA.work1.ed1:1.1#One.\n", []string{"citelibrary", "ctscatalog"}},
+	} {
+		blocks := SplitCEXBlocks(test.source)
+		rules, _ := RulesFor(ParseCEXVersion(blocks))
+		var missing []string
+		for _, diagnostic := range CheckBlocks(blocks, rules) {
+			missing = append(missing, diagnostic.Block)
+		}
+		if strings.Join(missing, " ") != strings.Join(test.missing, " ") {
+			t.Errorf("%q: got missing blocks %v, want %v", test.source, missing, test.missing)
+		}
+	}
+}
+
+//A CEX 3 catalog has to begin with a header line and have the lang column; older versions may leave out both.
+func TestCatalogVersionRules(t *testing.T) {
+	const entry = "urn:cts:citeArch:groupA.work1.ed1:#book,line#Group A#Work 1#Edition 1##true"
+	const header = "urn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online"
+	for _, test := range []struct {
+		version string
+		catalog string
+		entries int
+		reason  string
+	}{
+		{"3.0", header + "#lang\n" + entry + "#eng\n", 1, ""},
+		{"3.0", entry + "#eng\n", 1, "expected a header line"},
+		{"3.0", header + "#lang\n" + entry + "\n", 0, "expected 8 columns"},
+		{"2.0", entry + "\n", 1, ""},
+		{"2.0", header + "\n" + entry + "\n", 1, ""},
+	} {
+		cex := ParseCEX(CTSParams{Sourcetext: "#!cexversion\n" + test.version + "\n\n#!citelibrary\nname#Test\n\n#!ctscatalog\n" + test.catalog})
+		var reasons []string
+		for _, diagnostic := range cex.Diagnostics {
+			reasons = append(reasons, diagnostic.Reason)
+		}
+		if len(cex.Catalog.CatalogEntries) != test.entries {
+			t.Errorf("%v %q: got %v entries, want %v", test.version, test.catalog, len(cex.Catalog.CatalogEntries), test.entries)
+		}
+		switch {
+		case test.reason == "" && len(reasons) > 0:
+			t.Errorf("%v %q: got diagnostics %v", test.version, test.catalog, reasons)
+		case test.reason != "" && (len(reasons) != 1 || !strings.HasPrefix(reasons[0], test.reason)):
+			t.Errorf("%v %q: got diagnostics %v, want %q", test.version, test.catalog, reasons, test.reason)
+		}
+	}
+}
+
+//Returns the work of a library built from node URNs of a single version with empty texts.
+func testWork(t *testing.T, references ...string) *Work {
+	library := &Library{Works: map[string]*Work{}}
+	for _, reference := range references {
+		urn, err := ParseCTSURN("urn:cts:citeArch:groupA.work1.ed1:" + reference)
+		if err != nil {
+			t.Fatal(err)
+		}
+		library.addNode(urn, urn.String(), "", 0, "")
+	}
+	return library.Works["urn:cts:citeArch:groupA.work1.ed1"]
+}
+
+//Containers step to their siblings across the borders of their parents, and not beyond the first or last container of their depth.
+func TestSiblingContainers(t *testing.T) {
+	work := testWork(t, "1.1.1", "1.1.2", "1.2.1", "2.1.1", "2.1.2", "2.2.1")
+	for _, test := range []struct {
+		reference string
+		offset    int
+		want      string
+	}{
+		{"1.2", 1, "2.1"},
+		{"2.1", -1, "1.2"},
+		{"1.1", 1, "1.2"},
+		{"1.1", 3, "2.2"},
+		{"2.2", 1, ""},
+		{"1.1", -1, ""},
+		{"1", 1, "2"},
+		{"2", -1, "1"},
+		{"2", 1, ""},
+	} {
+		passage, _ := ParseCTSURN("urn:cts:citeArch:groupA.work1.ed1:" + test.reference)
+		container, found := work.FindCitation(passage.Begin)
+		if !found {
+			t.Fatalf("%v: container not found", test.reference)
+		}
+		got := ""
+		if sibling, found := work.Sibling(container, test.offset); found {
+			got = sibling.Reference
+		}
+		if got != test.want {
+			t.Errorf("%v %+d: got %q, want %q", test.reference, test.offset, got, test.want)
+		}
+	}
+}
+
+//The container after the last chapter of a book is the first chapter of the next book, served with its nodes and tier.
+func TestNextContainerAcrossBooks(t *testing.T) {
+	library := testLibrary(t, `#!ctscatalog
+urn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online#lang
+urn:cts:citeArch:groupA.work1.ed1:#book,chapter,line#Group A#Work 1#Edition 1##true#eng
+
+#!ctsdata
+urn:cts:citeArch:groupA.work1.ed1:1.1.1#One.
+urn:cts:citeArch:groupA.work1.ed1:1.2.1#Two.
+urn:cts:citeArch:groupA.work1.ed1:2.1.1#Three.
+urn:cts:citeArch:groupA.work1.ed1:2.1.2#Four.
+`)
+	for _, test := range []struct {
+		pattern string
+		handler func(http.ResponseWriter, *http.Request)
+		urn     string
+		want    string
+		nodes   int
+	}{
+		{"/{CEX}/texts/next/{URN}", ReturnNext, "urn:cts:citeArch:groupA.work1.ed1:1.2", "urn:cts:citeArch:groupA.work1.ed1:2.1", 2},
+		{"/{CEX}/texts/previous/{URN}", ReturnPrev, "urn:cts:citeArch:groupA.work1.ed1:2.1", "urn:cts:citeArch:groupA.work1.ed1:1.2", 1},
+	} {
+		var result NodeResponse
+		body := serveTest(t, library, test.pattern, test.handler, strings.Replace(strings.Replace(test.pattern, "{CEX}", "test", 1), "{URN}", test.urn, 1))
+		if err := json.Unmarshal([]byte(body), &result); err != nil {
+			t.Fatal(err)
+		}
+		if len(result.URN) != 1 || result.URN[0] != test.want || result.Tier != "chapter" || len(result.Nodes) != test.nodes {
+			t.Errorf("%v %v: got %v", test.pattern, test.urn, body)
+		}
+	}
+}
+
+//The citation hierarchy is walked down to the children, up to the parent and up to every ancestor, each labelled with its tier.
+func TestCitationHierarchy(t *testing.T) {
+	library := testLibrary(t, `#!ctscatalog
+urn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online#lang
+urn:cts:citeArch:groupA.work1.ed1:#book,chapter,line#Group A#Work 1#Edition 1##true#eng
+
+#!ctsdata
+urn:cts:citeArch:groupA.work1.ed1:1.1.1#One.
+urn:cts:citeArch:groupA.work1.ed1:1.2.1#Two.
+urn:cts:citeArch:groupA.work1.ed1:2.1.1#Three.
+urn:cts:citeArch:groupA.work1.ed1:2.1.2#Four.
+`)
+	for _, test := range []struct {
+		pattern string
+		handler func(http.ResponseWriter, *http.Request)
+		urn     string
+		want    []string //URN and tier of every citation
+	}{
+		{"/{CEX}/texts/children/{URN}", ReturnChildren, "urn:cts:citeArch:groupA.work1.ed1:", []string{"urn:cts:citeArch:groupA.work1.ed1:1 book", "urn:cts:citeArch:groupA.work1.ed1:2 book"}},
+		{"/{CEX}/texts/children/{URN}", ReturnChildren, "urn:cts:citeArch:groupA.work1.ed1:2.1", []string{"urn:cts:citeArch:groupA.work1.ed1:2.1.1 line", "urn:cts:citeArch:groupA.work1.ed1:2.1.2 line"}},
+		{"/{CEX}/texts/children/{URN}", ReturnChildren, "urn:cts:citeArch:groupA.work1.ed1:2.1.1", []string{}},
+		{"/{CEX}/texts/parent/{URN}", ReturnParent, "urn:cts:citeArch:groupA.work1.ed1:1.2.1", []string{"urn:cts:citeArch:groupA.work1.ed1:1.2 chapter"}},
+		{"/{CEX}/texts/parent/{URN}", ReturnParent, "urn:cts:citeArch:groupA.work1.ed1:1", []string{"urn:cts:citeArch:groupA.work1.ed1: version"}},
+		{"/{CEX}/texts/ancestors/{URN}", ReturnAncestors, "urn:cts:citeArch:groupA.work1.ed1:2.1.2", []string{"urn:cts:citeArch:groupA.work1.ed1: version", "urn:cts:citeArch:groupA.work1.ed1:2 book", "urn:cts:citeArch:groupA.work1.ed1:2.1 chapter"}},
+	} {
+		var result CitationResponse
+		body := serveTest(t, library, test.pattern, test.handler, strings.Replace(strings.Replace(test.pattern, "{CEX}", "test", 1), "{URN}", test.urn, 1))
+		if err := json.Unmarshal([]byte(body), &result); err != nil || result.Status != "Success" {
+			t.Errorf("%v %v: got %v", test.pattern, test.urn, body)
+			continue
+		}
+		got := []string{}
+		for _, citation := range result.Citations {
+			got = append(got, citation.URN+" "+citation.Tier)
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%v %v: got %v, want %v", test.pattern, test.urn, got, test.want)
+		}
+	}
+}
+
+//The table of contents counts the nodes, characters and words below each container, down to the requested depth.
+func TestTOC(t *testing.T) {
+	library := testLibrary(t, `#!ctscatalog
+urn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online#lang
+urn:cts:citeArch:groupA.work1.ed1:#book,chapter,line#Group A#Work 1#Edition 1##true#eng
+
+#!ctsdata
+urn:cts:citeArch:groupA.work1.ed1:1.1.1#One word.
+urn:cts:citeArch:groupA.work1.ed1:1.2.1#Two.
+urn:cts:citeArch:groupA.work1.ed1:2.1.1#Three more words.
+`)
+	for _, test := range []struct {
+		path     string
+		status   string
+		leaves   int
+		words    int
+		children []int //number of children of each child
+	}{
+		{"/test/texts/toc/urn:cts:citeArch:groupA.work1.ed1:", "Success", 3, 6, []int{2, 1}},
+		{"/test/texts/toc/urn:cts:citeArch:groupA.work1.ed1:?depth=0", "Success", 3, 6, []int{2, 1}},
+		{"/test/texts/toc/urn:cts:citeArch:groupA.work1.ed1:?depth=1", "Success", 3, 6, []int{0, 0}},
+		{"/test/texts/toc/urn:cts:citeArch:groupA.work1.ed1:1", "Success", 2, 3, []int{0, 0}},
+		{"/test/texts/toc/urn:cts:citeArch:groupA.work1.ed1:?depth=-1", "Exception", 0, 0, nil},
+		{"/test/texts/toc/urn:cts:citeArch:groupA.work1.ed1:?depth=one", "Exception", 0, 0, nil},
+		{"/test/texts/toc/urn:cts:citeArch:groupA.work1.ed1:1-2", "Exception", 0, 0, nil},
+		{"/test/texts/toc/urn:cts:citeArch:groupA.work1.ed1:3", "Exception", 0, 0, nil},
+	} {
+		var result TOCResponse
+		body := serveTest(t, library, "/{CEX}/texts/toc/{URN}", ReturnTOC, test.path)
+		if err := json.Unmarshal([]byte(body), &result); err != nil || result.Status != test.status {
+			t.Errorf("%v: got %v", test.path, body)
+			continue
+		}
+		if result.TOC == nil {
+			continue
+		}
+		var children []int
+		for _, child := range result.TOC.Children {
+			children = append(children, len(child.Children))
+		}
+		if result.TOC.Leaves != test.leaves || result.TOC.Words != test.words || fmt.Sprint(children) != fmt.Sprint(test.children) {
+			t.Errorf("%v: got %v leaves, %v words and children %v, want %v, %v and %v", test.path, result.TOC.Leaves, result.TOC.Words, children, test.leaves, test.words, test.children)
+		}
+	}
+}
+
+//References are cut to the requested level and listed once each in document order; shorter references are kept as they are.
+func TestReferences(t *testing.T) {
+	work := testWork(t, "1.1.1", "1.1.2", "1.2.1", "2.1.1", "2.2.1", "3")
+	for _, test := range []struct {
+		first, last, level int
+		want               string
+	}{
+		{0, 5, 1, "1 2 3"},
+		{0, 5, 2, "1.1 1.2 2.1 2.2 3"},
+		{0, 5, 3, "1.1.1 1.1.2 1.2.1 2.1.1 2.2.1 3"},
+		{0, 5, 9, "1.1.1 1.1.2 1.2.1 2.1.1 2.2.1 3"},
+		{1, 3, 2, "1.1 1.2 2.1"},
+		{4, 4, 1, "2"},
+	} {
+		var got []string
+		for _, reference := range work.References(test.first, test.last, test.level) {
+			got = append(got, strings.TrimPrefix(reference, work.WorkURN+":"))
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("References(%v, %v, %v): got %v, want %v", test.first, test.last, test.level, got, test.want)
+		}
+	}
+}
+
+//The level of /texts/urns has to be a positive number.
+func TestReturnReffLevel(t *testing.T) {
+	library := testLibrary(t, fullSource)
+	for _, test := range []struct {
+		query  string
+		status string
+		urns   int
+	}{
+		{"", "Success", 2},
+		{"?level=1", "Success", 1},
+		{"?level=2", "Success", 2},
+		{"?level=0", "Exception", 0},
+		{"?level=-1", "Exception", 0},
+		{"?level=book", "Exception", 0},
+	} {
+		var result URNResponse
+		body := serveTest(t, library, "/{CEX}/texts/urns/{URN}", ReturnReff, "/test/texts/urns/urn:cts:citeArch:groupA.work1.ed1:"+test.query)
+		if err := json.Unmarshal([]byte(body), &result); err != nil || result.Status != test.status || len(result.URN) != test.urns {
+			t.Errorf("%q: got %v", test.query, body)
+		}
+	}
+}
+
+//The context is cut at the beginning and end of the work, however many nodes or containers are requested.
+func TestContextClamps(t *testing.T) {
+	work := testWork(t, "1.1", "1.2", "2.1", "2.2", "3.1")
+	for _, test := range []struct{ first, last, count, depth, wantFirst, wantLast int }{
+		{2, 2, 1, 0, 1, 3},
+		{2, 2, int(^uint(0) >> 1), 0, 0, 4},
+		{2, 3, 0, 1, 2, 3},
+		{2, 2, 1, 1, 0, 4},
+		{0, 0, 1000000000, 1, 0, 4},
+	} {
+		first, last := work.Context(test.first, test.last, test.count, test.depth)
+		if first != test.wantFirst || last != test.wantLast {
+			t.Errorf("Context(%d, %d, %d, %d) = %d, %d, want %d, %d", test.first, test.last, test.count, test.depth, first, last, test.wantFirst, test.wantLast)
+		}
+	}
+}
+
+//Context in containers is counted in the containers around the passage; nodes of a one-level work have none.
+func TestReturnContextUnits(t *testing.T) {
+	library := testLibrary(t, orcaSource)
+	for _, test := range []struct {
+		path   string
+		status string
+		urns   string
+	}{
+		{"/test/texts/context/urn:cts:greekLit:tlg0012.tlg001.msA:1.1?n=1", "Success", "1.1 1.2"},
+		{"/test/texts/context/urn:cts:greekLit:tlg0012.tlg001.msA:1.1?n=0", "Success", "1.1"},
+		{"/test/texts/context/urn:cts:greekLit:tlg0012.tlg001.msA:1.1?n=0&unit=container", "Success", "1.1 1.2"},
+		{"/test/texts/context/urn:cts:greekLit:tlg0012.tlg002.msA:1?n=1", "Success", "1"},
+		{"/test/texts/context/urn:cts:greekLit:tlg0012.tlg002.msA:1?n=1&unit=container", "Exception", ""},
+		{"/test/texts/context/urn:cts:greekLit:tlg0012.tlg001.msA:1.1?unit=chapter", "Exception", ""},
+		{"/test/texts/context/urn:cts:greekLit:tlg0012.tlg001.msA:1.1?n=-1", "Exception", ""},
+	} {
+		var result NodeResponse
+		body := serveTest(t, library, "/{CEX}/texts/context/{URN}", ReturnContext, test.path)
+		if err := json.Unmarshal([]byte(body), &result); err != nil || result.Status != test.status {
+			t.Errorf("%v: got %v", test.path, body)
+			continue
+		}
+		var urns []string
+		for _, node := range result.Nodes {
+			urns = append(urns, node.URN[0][strings.LastIndex(node.URN[0], ":")+1:])
+		}
+		if strings.Join(urns, " ") != test.urns {
+			t.Errorf("%v: got %v, want %v", test.path, urns, test.urns)
+		}
+	}
+}